@@ -0,0 +1,461 @@
+package clients
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// AMQPChecker opens an AMQP 0-9-1 connection (RabbitMQ) with the host's USERNAME and PASSWORD and opens VHOST, which
+// defaults to "/". Then every queue in QUEUES and exchange in EXCHANGES (comma separated) is declared passively, which
+// fails if it doesn't exist or the user can't access it, without creating anything. TLS=true connects with TLS, see
+// tls.go
+type AMQPChecker struct{}
+
+func init() {
+	Register(&AMQPChecker{})
+}
+
+const (
+	amqpFrameMethod    byte = 1
+	amqpFrameHeartbeat byte = 8
+	amqpFrameEnd       byte = 0xce
+	amqpMaxFrameSize        = 128 * 1024
+
+	amqpConnection uint16 = 10
+	amqpChannel    uint16 = 20
+	amqpExchange   uint16 = 40
+	amqpQueue      uint16 = 50
+
+	// reply codes from the spec
+	amqpAccessRefused uint16 = 403
+	amqpNotAllowed    uint16 = 530
+)
+
+var amqpProtocolHeader = []byte("AMQP\x00\x00\x09\x01")
+
+func (c *AMQPChecker) Name() string {
+	return "AMQP 0-9-1"
+}
+
+func (c *AMQPChecker) Prefix() string {
+	return "AMQP"
+}
+
+func (c *AMQPChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *AMQPChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "ADDRESS", Required: true},
+		{Name: "PORT", Required: true}, // 5672, or 5671 with TLS
+		{Name: "USERNAME", Required: true},
+		{Name: "PASSWORD"},
+		{Name: "VHOST"},
+		{Name: "QUEUES"},
+		{Name: "EXCHANGES"},
+	}, TLSFields...)
+}
+
+func (c *AMQPChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	product, err := c.check(ctx, host)
+	if err != nil {
+		res = res.Fail(err)
+	} else {
+		msg := fmt.Sprintf("opened vhost %s as %s (%s)", amqpVHost(host), host["USERNAME"], product)
+		declared := append(splitList(host["QUEUES"]), splitList(host["EXCHANGES"])...)
+		if len(declared) > 0 {
+			msg += fmt.Sprintf(", found %s", strings.Join(declared, ", "))
+		}
+		res = res.Pass(msg)
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// check logs in, opens the vhost and declares the queues and exchanges. It returns the server product and version
+func (c *AMQPChecker) check(ctx context.Context, host map[string]string) (string, error) {
+	useTLS, err := TLSEnabled(host)
+	if err != nil {
+		return "", err
+	}
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return "", fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, target, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		cfg, err := TLSConfig(host)
+		if err != nil {
+			return "", err
+		}
+		tlsConn, err := Handshake(conn, cfg, target)
+		if err != nil {
+			return "", err
+		}
+		conn = tlsConn
+	}
+
+	a := &amqpConn{conn: conn, reader: bufio.NewReader(conn)}
+	product, err := a.open(host["USERNAME"], host["PASSWORD"], amqpVHost(host))
+	if err != nil {
+		return "", err
+	}
+	for _, queue := range splitList(host["QUEUES"]) {
+		if err := a.declare(amqpQueue, "queue", queue); err != nil {
+			return "", err
+		}
+	}
+	for _, exchange := range splitList(host["EXCHANGES"]) {
+		if err := a.declare(amqpExchange, "exchange", exchange); err != nil {
+			return "", err
+		}
+	}
+	a.close()
+	return product, nil
+}
+
+func amqpVHost(host map[string]string) string {
+	if host["VHOST"] != "" {
+		return host["VHOST"]
+	}
+	return "/"
+}
+
+// amqpConn reads and writes method frames. Only channel 1 is used after the connection is open
+type amqpConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	channelOpen bool
+	hungUp      bool
+}
+
+// open runs the connection handshake up to Connection.OpenOk
+func (a *amqpConn) open(username, password, vhost string) (string, error) {
+	if _, err := a.conn.Write(amqpProtocolHeader); err != nil {
+		return "", fmt.Errorf("%w: writing to server: %v", ErrNetwork, err)
+	}
+	r, err := a.expect(0, amqpConnection, 10)
+	if err != nil {
+		return "", err
+	}
+	r.take(2) // version
+	props := r.table()
+	mechanisms := string(r.longString())
+	if r.err != nil {
+		return "", r.err
+	}
+	if !strings.Contains(" "+mechanisms+" ", " PLAIN ") {
+		return "", fmt.Errorf("%w: server doesn't offer PLAIN authentication: %s", ErrProtocol, mechanisms)
+	}
+
+	var w amqpWriter
+	// authentication_failure_close makes RabbitMQ say why it's closing the connection instead of just closing it
+	w.table(map[string]interface{}{
+		"product":      "preflight",
+		"capabilities": map[string]interface{}{"authentication_failure_close": true},
+	})
+	w.shortString("PLAIN")
+	w.longString("\x00" + username + "\x00" + password)
+	w.shortString("en_US")
+	if err := a.send(0, amqpConnection, 11, w.buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	r, err = a.expect(0, amqpConnection, 30)
+	if err != nil {
+		if a.hungUp {
+			// servers without authentication_failure_close just hang up on a bad login
+			return "", fmt.Errorf("%w: server closed the connection after login. check USERNAME and PASSWORD", ErrAuth)
+		}
+		return "", err
+	}
+	channelMax, frameMax := r.uint16(), r.uint32()
+	if frameMax == 0 || frameMax > amqpMaxFrameSize {
+		frameMax = amqpMaxFrameSize
+	}
+	w = amqpWriter{}
+	w.uint16(channelMax)
+	w.uint32(frameMax)
+	w.uint16(0) // no heartbeats
+	if err := a.send(0, amqpConnection, 31, w.buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	w = amqpWriter{}
+	w.shortString(vhost)
+	w.shortString("")
+	w.buf.WriteByte(0)
+	if err := a.send(0, amqpConnection, 40, w.buf.Bytes()); err != nil {
+		return "", err
+	}
+	if _, err := a.expect(0, amqpConnection, 41); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", props["product"], props["version"]), nil
+}
+
+// declare passively declares a queue or exchange. A channel error closes the channel, so it's reopened as needed
+func (a *amqpConn) declare(class uint16, kind, name string) error {
+	if !a.channelOpen {
+		if err := a.send(1, amqpChannel, 10, []byte{0}); err != nil {
+			return err
+		}
+		if _, err := a.expect(1, amqpChannel, 11); err != nil {
+			return err
+		}
+		a.channelOpen = true
+	}
+
+	var w amqpWriter
+	w.uint16(0)
+	w.shortString(name)
+	if class == amqpExchange {
+		w.shortString("")
+	}
+	w.buf.WriteByte(1) // passive
+	w.uint32(0)        // no arguments
+	if err := a.send(1, class, 10, w.buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := a.expect(1, class, 11); err != nil {
+		return fmt.Errorf("%s %s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// close says goodbye so the server doesn't log an unexpected disconnect
+func (a *amqpConn) close() {
+	var w amqpWriter
+	w.uint16(200)
+	w.shortString("bye")
+	w.uint32(0)
+	if err := a.send(0, amqpConnection, 50, w.buf.Bytes()); err == nil {
+		_, _ = a.expect(0, amqpConnection, 51)
+	}
+}
+
+// expect reads the next method and makes sure it's the one expected. Connection.Close and Channel.Close are
+// acknowledged and turned into errors
+func (a *amqpConn) expect(channel, class, method uint16) (*amqpReader, error) {
+	for {
+		typ, ch, payload, err := a.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if typ == amqpFrameHeartbeat {
+			continue
+		}
+		r := &amqpReader{buf: payload}
+		gotClass, gotMethod := r.uint16(), r.uint16()
+		if typ != amqpFrameMethod || r.err != nil {
+			return nil, fmt.Errorf("%w: expected a method frame, got frame type %d", ErrProtocol, typ)
+		}
+		switch {
+		case gotClass == amqpConnection && gotMethod == 50:
+			_ = a.send(0, amqpConnection, 51, nil)
+			return nil, amqpCloseError(r, "connection")
+		case gotClass == amqpChannel && gotMethod == 40:
+			_ = a.send(ch, amqpChannel, 41, nil)
+			a.channelOpen = false
+			return nil, amqpCloseError(r, "channel")
+		case ch != channel || gotClass != class || gotMethod != method:
+			return nil, fmt.Errorf("%w: expected method %d.%d, got %d.%d", ErrProtocol, class, method, gotClass, gotMethod)
+		}
+		return r, nil
+	}
+}
+
+// amqpCloseError reads the reply code and text from a Connection.Close or Channel.Close
+func amqpCloseError(r *amqpReader, what string) error {
+	code, text := r.uint16(), r.shortString()
+	kind := ErrRejected
+	switch code {
+	case amqpAccessRefused:
+		kind = ErrAuth
+	case amqpNotAllowed:
+		// RabbitMQ answers Connection.Open with NOT_ALLOWED for a vhost that doesn't exist or the user can't use
+		text += ". check that VHOST exists and USERNAME has permissions on it"
+	}
+	return fmt.Errorf("%w: server closed the %s: %d %s", kind, what, code, text)
+}
+
+func (a *amqpConn) readFrame() (byte, uint16, []byte, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadFull(a.reader, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			a.hungUp = true
+			return 0, 0, nil, fmt.Errorf("%w: server closed the connection", ErrNetwork)
+		}
+		return 0, 0, nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	size := binary.BigEndian.Uint32(header[3:])
+	if size > amqpMaxFrameSize {
+		return 0, 0, nil, fmt.Errorf("%w: frame of %d bytes is too big", ErrProtocol, size)
+	}
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(a.reader, payload); err != nil {
+		return 0, 0, nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	if payload[size] != amqpFrameEnd {
+		return 0, 0, nil, fmt.Errorf("%w: bad frame end", ErrProtocol)
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:]), payload[:size], nil
+}
+
+func (a *amqpConn) send(channel, class, method uint16, args []byte) error {
+	var w amqpWriter
+	w.buf.WriteByte(amqpFrameMethod)
+	w.uint16(channel)
+	w.uint32(uint32(4 + len(args)))
+	w.uint16(class)
+	w.uint16(method)
+	w.buf.Write(args)
+	w.buf.WriteByte(amqpFrameEnd)
+	if _, err := a.conn.Write(w.buf.Bytes()); err != nil {
+		return fmt.Errorf("%w: writing to server: %v", ErrNetwork, err)
+	}
+	return nil
+}
+
+type amqpWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *amqpWriter) uint16(v uint16) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *amqpWriter) uint32(v uint32) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *amqpWriter) shortString(s string) {
+	w.buf.WriteByte(byte(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *amqpWriter) longString(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+// table writes a field table. Only the value types the client properties need are supported
+func (w *amqpWriter) table(t map[string]interface{}) {
+	var fields amqpWriter
+	for k, v := range t {
+		fields.shortString(k)
+		switch v := v.(type) {
+		case string:
+			fields.buf.WriteByte('S')
+			fields.longString(v)
+		case bool:
+			fields.buf.WriteByte('t')
+			if v {
+				fields.buf.WriteByte(1)
+			} else {
+				fields.buf.WriteByte(0)
+			}
+		case map[string]interface{}:
+			fields.buf.WriteByte('F')
+			fields.table(v)
+		}
+	}
+	w.uint32(uint32(fields.buf.Len()))
+	w.buf.Write(fields.buf.Bytes())
+}
+
+// amqpReader decodes method arguments. Like kafkaReader, the first short read sets err and later reads return zero
+// values
+type amqpReader struct {
+	buf []byte
+	err error
+}
+
+func (r *amqpReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = fmt.Errorf("%w: method frame is truncated", ErrProtocol)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *amqpReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *amqpReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *amqpReader) shortString() string {
+	if b := r.take(1); b != nil {
+		return string(r.take(int(b[0])))
+	}
+	return ""
+}
+
+func (r *amqpReader) longString() []byte {
+	return r.take(int(r.uint32()))
+}
+
+// table reads a field table and returns its string values. Everything else is skipped
+func (r *amqpReader) table() map[string]string {
+	res := make(map[string]string)
+	fields := &amqpReader{buf: r.longString()}
+	for len(fields.buf) > 0 && fields.err == nil {
+		name := fields.shortString()
+		typ := fields.take(1)
+		if typ == nil {
+			break
+		}
+		switch typ[0] {
+		case 'S':
+			res[name] = string(fields.longString())
+		case 't', 'b', 'B':
+			fields.take(1)
+		case 's', 'u':
+			fields.take(2)
+		case 'I', 'i', 'f':
+			fields.take(4)
+		case 'D':
+			fields.take(5)
+		case 'l', 'L', 'd', 'T':
+			fields.take(8)
+		case 'A', 'F', 'x':
+			fields.longString()
+		case 'V':
+		default:
+			fields.err = fmt.Errorf("%w: unknown field type %q", ErrProtocol, typ[0])
+		}
+	}
+	if fields.err != nil && r.err == nil {
+		r.err = fields.err
+	}
+	return res
+}
@@ -0,0 +1,178 @@
+package clients
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeRabbit is a stand-in for RabbitMQ with one user, two vhosts and a few queues and exchanges. hangUp makes it drop
+// bad logins without a Connection.Close, like servers that don't support authentication_failure_close
+type fakeRabbit struct {
+	*fakeServer
+	hangUp    bool
+	queues    map[string]bool
+	exchanges map[string]bool
+}
+
+func startFakeRabbit(t *testing.T, hangUp bool) *fakeRabbit {
+	f := &fakeRabbit{
+		hangUp:    hangUp,
+		queues:    map[string]bool{"orders": true, "private": true},
+		exchanges: map[string]bool{"events": true},
+	}
+	f.fakeServer = startFakeServer(t, f.serve)
+	return f
+}
+
+func (f *fakeRabbit) host(fields map[string]string) map[string]string {
+	host := f.hostMap("AMQP", "JOBS", map[string]string{"USERNAME": "jobs", "PASSWORD": "goodpassword"})
+	for k, v := range fields {
+		host[k] = v
+	}
+	return host
+}
+
+func (f *fakeRabbit) serve(conn net.Conn) {
+	a := &amqpConn{conn: conn, reader: bufio.NewReader(conn)}
+	header := make([]byte, len(amqpProtocolHeader))
+	if _, err := io.ReadFull(a.reader, header); err != nil || string(header) != string(amqpProtocolHeader) {
+		return
+	}
+
+	var w amqpWriter
+	w.buf.Write([]byte{0, 9})
+	w.table(map[string]interface{}{"product": "RabbitMQ", "version": "3.8.9",
+		"capabilities": map[string]interface{}{"authentication_failure_close": true}})
+	w.longString("AMQPLAIN PLAIN")
+	w.longString("en_US")
+	_ = a.send(0, amqpConnection, 10, w.buf.Bytes())
+
+	r, err := a.expect(0, amqpConnection, 11)
+	if err != nil {
+		return
+	}
+	r.table()
+	r.shortString()
+	if string(r.longString()) != "\x00jobs\x00goodpassword" {
+		if !f.hangUp {
+			fakeRabbitClose(a, 0, amqpConnection, 403, "ACCESS_REFUSED - Login was refused")
+		}
+		return
+	}
+	w = amqpWriter{}
+	w.uint16(2047)
+	w.uint32(131072)
+	w.uint16(60)
+	_ = a.send(0, amqpConnection, 30, w.buf.Bytes())
+	if _, err := a.expect(0, amqpConnection, 31); err != nil {
+		return
+	}
+	r, err = a.expect(0, amqpConnection, 40)
+	if err != nil {
+		return
+	}
+	if vhost := r.shortString(); vhost != "/" && vhost != "jobs" {
+		fakeRabbitClose(a, 0, amqpConnection, amqpNotAllowed, "NOT_ALLOWED - vhost "+vhost+" not found")
+		return
+	}
+	_ = a.send(0, amqpConnection, 41, []byte{0})
+
+	for {
+		typ, ch, payload, err := a.readFrame()
+		if err != nil || typ != amqpFrameMethod {
+			return
+		}
+		r := &amqpReader{buf: payload}
+		class, method := r.uint16(), r.uint16()
+		switch {
+		case class == amqpChannel && method == 10:
+			_ = a.send(ch, amqpChannel, 11, []byte{0, 0, 0, 0})
+		case class == amqpChannel && method == 41:
+		case class == amqpQueue && method == 10:
+			r.uint16()
+			name := r.shortString()
+			switch {
+			case name == "private":
+				fakeRabbitClose(a, ch, amqpChannel, amqpAccessRefused, "ACCESS_REFUSED - access to queue 'private' refused")
+			case f.queues[name]:
+				w = amqpWriter{}
+				w.shortString(name)
+				w.uint32(0)
+				w.uint32(1)
+				_ = a.send(ch, amqpQueue, 11, w.buf.Bytes())
+			default:
+				fakeRabbitClose(a, ch, amqpChannel, 404, "NOT_FOUND - no queue '"+name+"' in vhost '/'")
+			}
+		case class == amqpExchange && method == 10:
+			r.uint16()
+			if name := r.shortString(); f.exchanges[name] {
+				_ = a.send(ch, amqpExchange, 11, nil)
+			} else {
+				fakeRabbitClose(a, ch, amqpChannel, 404, "NOT_FOUND - no exchange '"+name+"' in vhost '/'")
+			}
+		case class == amqpConnection && method == 50:
+			_ = a.send(0, amqpConnection, 51, nil)
+			return
+		default:
+			return
+		}
+	}
+}
+
+func fakeRabbitClose(a *amqpConn, channel, class, code uint16, text string) {
+	var w amqpWriter
+	w.uint16(code)
+	w.shortString(text)
+	w.uint32(0)
+	_ = a.send(channel, class, map[uint16]uint16{amqpConnection: 50, amqpChannel: 40}[class], w.buf.Bytes())
+}
+
+func TestAMQPChecker(t *testing.T) {
+	f := startFakeRabbit(t, false)
+	defer f.Close()
+
+	cases := []struct {
+		name   string
+		fields map[string]string
+		kind   error
+	}{
+		{"default vhost", map[string]string{}, nil},
+		{"queues and exchanges", map[string]string{"VHOST": "jobs", "QUEUES": "orders", "EXCHANGES": "events"}, nil},
+		{"bad password", map[string]string{"PASSWORD": "oldpassword"}, ErrAuth},
+		{"missing vhost", map[string]string{"VHOST": "staging"}, ErrRejected},
+		{"missing queue", map[string]string{"QUEUES": "orders,refunds"}, ErrRejected},
+		{"missing exchange", map[string]string{"EXCHANGES": "audit"}, ErrRejected},
+		{"queue permissions", map[string]string{"QUEUES": "private"}, ErrAuth},
+	}
+	for _, c := range cases {
+		res := runChecker(&AMQPChecker{}, f.host(c.fields))
+		if c.kind == nil && !res.OK {
+			t.Errorf("%s: expected the check to pass: %s", c.name, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.kind, res.Err)
+		}
+	}
+
+	res := runChecker(&AMQPChecker{}, f.host(map[string]string{"QUEUES": "refunds"}))
+	if !strings.Contains(res.Message, "queue refunds") || !strings.Contains(res.Message, "NOT_FOUND") {
+		t.Errorf("expected the missing queue to be named: %s", res.Message)
+	}
+	res = runChecker(&AMQPChecker{}, f.host(map[string]string{"VHOST": "staging"}))
+	if !strings.Contains(res.Message, "VHOST") {
+		t.Errorf("expected a hint about the vhost: %s", res.Message)
+	}
+}
+
+func TestAMQPCheckerHangUp(t *testing.T) {
+	f := startFakeRabbit(t, true)
+	defer f.Close()
+	res := runChecker(&AMQPChecker{}, f.host(map[string]string{"PASSWORD": "oldpassword"}))
+	if res.OK || !errors.Is(res.Err, ErrAuth) {
+		t.Errorf("expected a hang up after login to be an auth failure: %v", res.Err)
+	}
+}
@@ -0,0 +1,212 @@
+package clients
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Just enough BSON for MongoDB commands. Commands need their keys in order (the command name has to come first), so
+// documents are encoded from a bsonDoc. Replies are decoded into maps since nothing cares about their order
+
+type bsonDoc []bsonElem
+
+type bsonElem struct {
+	Key   string
+	Value interface{}
+}
+
+// bsonEncode supports the value types commands need: bsonDoc, []string, string, bool, int32, int64, float64 and []byte,
+// which is encoded as generic binary
+func bsonEncode(doc bsonDoc) ([]byte, error) {
+	var body bytes.Buffer
+	for _, e := range doc {
+		var kind byte
+		var value bytes.Buffer
+		switch v := e.Value.(type) {
+		case bsonDoc:
+			kind = 0x03
+			b, err := bsonEncode(v)
+			if err != nil {
+				return nil, err
+			}
+			value.Write(b)
+		case []string:
+			// arrays are documents keyed "0", "1", ...
+			kind = 0x04
+			arr := make(bsonDoc, len(v))
+			for i, item := range v {
+				arr[i] = bsonElem{fmt.Sprint(i), item}
+			}
+			b, err := bsonEncode(arr)
+			if err != nil {
+				return nil, err
+			}
+			value.Write(b)
+		case string:
+			kind = 0x02
+			_ = binary.Write(&value, binary.LittleEndian, int32(len(v)+1))
+			value.WriteString(v)
+			value.WriteByte(0)
+		case bool:
+			kind = 0x08
+			if v {
+				value.WriteByte(1)
+			} else {
+				value.WriteByte(0)
+			}
+		case int32:
+			kind = 0x10
+			_ = binary.Write(&value, binary.LittleEndian, v)
+		case int64:
+			kind = 0x12
+			_ = binary.Write(&value, binary.LittleEndian, v)
+		case float64:
+			kind = 0x01
+			_ = binary.Write(&value, binary.LittleEndian, math.Float64bits(v))
+		case []byte:
+			kind = 0x05
+			_ = binary.Write(&value, binary.LittleEndian, int32(len(v)))
+			value.WriteByte(0)
+			value.Write(v)
+		default:
+			return nil, fmt.Errorf("can't encode %T as BSON", e.Value)
+		}
+		body.WriteByte(kind)
+		body.WriteString(e.Key)
+		body.WriteByte(0)
+		body.Write(value.Bytes())
+	}
+	res := make([]byte, 4, body.Len()+5)
+	binary.LittleEndian.PutUint32(res, uint32(body.Len()+5))
+	res = append(res, body.Bytes()...)
+	return append(res, 0), nil
+}
+
+// bsonDecode decodes a document. Embedded documents become maps, arrays become []interface{} and numbers keep their
+// BSON type. Types that commands don't return, like ObjectIds and timestamps, are skipped
+func bsonDecode(b []byte) (map[string]interface{}, error) {
+	if len(b) < 5 || int(binary.LittleEndian.Uint32(b)) != len(b) || b[len(b)-1] != 0 {
+		return nil, fmt.Errorf("%w: bad BSON document", ErrProtocol)
+	}
+	res := make(map[string]interface{})
+	b = b[4 : len(b)-1]
+	for len(b) > 0 {
+		kind := b[0]
+		end := bytes.IndexByte(b[1:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("%w: bad BSON key", ErrProtocol)
+		}
+		key := string(b[1 : end+1])
+		b = b[end+2:]
+
+		size, err := bsonValueSize(kind, b)
+		if err != nil {
+			return nil, err
+		}
+		v := b[:size]
+		b = b[size:]
+		switch kind {
+		case 0x01:
+			res[key] = math.Float64frombits(binary.LittleEndian.Uint64(v))
+		case 0x02:
+			res[key] = string(v[4 : len(v)-1])
+		case 0x03, 0x04:
+			doc, err := bsonDecode(v)
+			if err != nil {
+				return nil, err
+			}
+			if kind == 0x03 {
+				res[key] = doc
+				continue
+			}
+			// arrays are documents keyed "0", "1", ...
+			arr := make([]interface{}, len(doc))
+			for i := range arr {
+				arr[i] = doc[fmt.Sprint(i)]
+			}
+			res[key] = arr
+		case 0x05:
+			res[key] = v[5:]
+		case 0x08:
+			res[key] = v[0] != 0
+		case 0x0a:
+			res[key] = nil
+		case 0x10:
+			res[key] = int32(binary.LittleEndian.Uint32(v))
+		case 0x12:
+			res[key] = int64(binary.LittleEndian.Uint64(v))
+		}
+	}
+	return res, nil
+}
+
+// bsonValueSize returns how many bytes the value of the given type takes at the start of b
+func bsonValueSize(kind byte, b []byte) (int, error) {
+	size := 0
+	switch kind {
+	case 0x0a, 0x06, 0x7f, 0xff: // null, undefined, max key, min key
+	case 0x08:
+		size = 1
+	case 0x10:
+		size = 4
+	case 0x01, 0x09, 0x11, 0x12: // double, datetime, timestamp, int64
+		size = 8
+	case 0x07: // ObjectId
+		size = 12
+	case 0x13: // decimal128
+		size = 16
+	case 0x02, 0x0d, 0x0e: // string, javascript, symbol
+		if len(b) < 4 {
+			return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+		}
+		size = 4 + int(int32(binary.LittleEndian.Uint32(b)))
+	case 0x03, 0x04:
+		if len(b) < 4 {
+			return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+		}
+		size = int(int32(binary.LittleEndian.Uint32(b)))
+	case 0x05:
+		if len(b) < 4 {
+			return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+		}
+		size = 5 + int(int32(binary.LittleEndian.Uint32(b)))
+	case 0x0b: // regex: two cstrings
+		first := bytes.IndexByte(b, 0)
+		if first < 0 {
+			return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+		}
+		second := bytes.IndexByte(b[first+1:], 0)
+		if second < 0 {
+			return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+		}
+		size = first + second + 2
+	default:
+		return 0, fmt.Errorf("%w: unsupported BSON type 0x%02x", ErrProtocol, kind)
+	}
+	// strings, documents and binary always have a length prefix and a terminator or subtype byte
+	variable := kind == 0x02 || kind == 0x0d || kind == 0x0e || kind == 0x03 || kind == 0x04 || kind == 0x05
+	if (variable && size < 5) || size < 0 || size > len(b) {
+		return 0, fmt.Errorf("%w: truncated BSON value", ErrProtocol)
+	}
+	return size, nil
+}
+
+// bsonNumber returns a numeric value as a float64, since servers are free to send ok and error codes as any number
+// type
+func bsonNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case bool:
+		if n {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Checker tests access to a single dependency described by a host map.  The host map is the one built by
+// config.GetHosts, so it always has "ID" and "CLIENT" keys plus whatever fields were set for the host:
+//
+//	{
+//		"ID": "HOT_PICKLES",
+//		"CLIENT": "POSTGRES10",
+//		"ADDRESS": "db.domain.com",
+//		"PORT": "5432",
+//	}
+//
+// Adding a new client type means writing a Checker and registering it with Register.
+type Checker interface {
+	// Name is a human readable name for the client type, like "PostgreSQL 10"
+	Name() string
+	// Prefix is the reserved environment variable prefix that selects this checker, like "POSTGRES10"
+	Prefix() string
+	// RequiredFields lists the host map fields the checker can't run without, like "ADDRESS" and "PORT"
+	RequiredFields() []string
+	// Run checks access to the host. It must give up when ctx is done
+	Run(ctx context.Context, host map[string]string) Result
+}
+
+// Result is the outcome of running a Checker against one host
+type Result struct {
+	ID       string
+	Client   string
+	OK       bool
+	Message  string
+	Err      error
+	Duration time.Duration
+}
+
+// NewResult starts a result for the host. Checkers fill in the rest with Pass or Fail
+func NewResult(host map[string]string) Result {
+	return Result{
+		ID:     host["ID"],
+		Client: host["CLIENT"],
+	}
+}
+
+// Pass marks the result successful
+func (r Result) Pass(msg string) Result {
+	r.OK = true
+	r.Message = msg
+	r.Err = nil
+	return r
+}
+
+// Fail marks the result failed with the error that caused it
+func (r Result) Fail(err error) Result {
+	r.OK = false
+	r.Message = err.Error()
+	r.Err = err
+	return r
+}
+
+// String is used when logging results
+func (r Result) String() string {
+	status := "passed"
+	if !r.OK {
+		status = "failed"
+	}
+	return fmt.Sprintf("%s check %s for host %s: %s", r.Client, status, r.ID, r.Message)
+}
+
+// MissingFields returns the required fields that are unset or empty in the host map
+func MissingFields(c Checker, host map[string]string) []string {
+	var res []string
+	for _, field := range c.RequiredFields() {
+		if host[field] == "" {
+			res = append(res, field)
+		}
+	}
+	return res
+}
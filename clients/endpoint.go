@@ -0,0 +1,31 @@
+package clients
+
+import (
+	"net"
+)
+
+// Endpoint is an address and port that has to be reachable before a client check can pass
+type Endpoint struct {
+	Address string
+	Port    string
+}
+
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Address, e.Port)
+}
+
+// Endpointer is implemented by checkers whose hosts aren't described by ADDRESS and PORT fields, like a URL. The
+// resolve and tcp checks use its endpoints instead
+type Endpointer interface {
+	Endpoints(host map[string]string) ([]Endpoint, error)
+}
+
+// Endpoints returns the endpoints for a host map. Unless the host's checker says otherwise that's just ADDRESS:PORT
+func Endpoints(host map[string]string) ([]Endpoint, error) {
+	if c, ok := Lookup(host["CLIENT"]); ok {
+		if e, ok := c.(Endpointer); ok {
+			return e.Endpoints(host)
+		}
+	}
+	return []Endpoint{{Address: host["ADDRESS"], Port: host["PORT"]}}, nil
+}
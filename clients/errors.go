@@ -0,0 +1,19 @@
+package clients
+
+import "errors"
+
+// Checkers wrap these errors so callers can tell what kind of failure they're looking at with errors.Is.  The
+// distinction matters to whoever reads the logs: a network failure goes to whoever owns routing and security groups,
+// an auth failure goes to whoever owns the credentials.
+var (
+	// ErrNetwork means the host couldn't be reached or the connection broke
+	ErrNetwork = errors.New("network failure")
+	// ErrAuth means the host was reached but refused the credentials
+	ErrAuth = errors.New("authentication failed")
+	// ErrRejected means the host accepted the credentials but refused the request, like a missing database
+	ErrRejected = errors.New("request rejected")
+	// ErrTLS means the TLS handshake failed, like an untrusted or expired certificate or one for another name
+	ErrTLS = errors.New("TLS failure")
+	// ErrProtocol means the host answered with something the checker didn't expect
+	ErrProtocol = errors.New("protocol error")
+)
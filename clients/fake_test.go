@@ -0,0 +1,73 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeServer is the listener the checker tests run their protocol fakes on. Every connection is handed to the fake's
+// handler in its own goroutine and closed when the handler returns, so the fakes only have to speak their protocol
+type fakeServer struct {
+	ln net.Listener
+}
+
+func startFakeServer(t *testing.T, handler func(net.Conn)) *fakeServer {
+	return startFakeTLSServer(t, nil, handler)
+}
+
+// startFakeTLSServer is startFakeServer for services that speak TLS from the first byte. A nil tlsConfig means plain
+// tcp
+func startFakeTLSServer(t *testing.T, tlsConfig *tls.Config, handler func(net.Conn)) *fakeServer {
+	var ln net.Listener
+	var err error
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				handler(conn)
+			}()
+		}
+	}()
+	return &fakeServer{ln: ln}
+}
+
+func (s *fakeServer) Close() {
+	_ = s.ln.Close()
+}
+
+// addr is the server's address as host:port
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+// hostMap returns a host map for a client pointing at the server. fields are added on top
+func (s *fakeServer) hostMap(client, id string, fields map[string]string) map[string]string {
+	address, port, _ := net.SplitHostPort(s.addr())
+	host := map[string]string{"ID": id, "CLIENT": client, "ADDRESS": address, "PORT": port}
+	for k, v := range fields {
+		host[k] = v
+	}
+	return host
+}
+
+// runChecker runs a checker against a host with a timeout that's plenty for the local fakes
+func runChecker(c Checker, host map[string]string) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Run(ctx, host)
+}
@@ -0,0 +1,241 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// GRPCChecker calls grpc.health.v1.Health/Check on the host and fails unless the status is SERVING. SERVICE is the
+// service name to ask about. Leaving it empty asks about the server as a whole, like grpc_health_probe does. TLS=true
+// connects with TLS, see tls.go. Otherwise the call is made over cleartext HTTP/2 (h2c).
+//
+// The health messages are small enough that they're encoded by hand instead of pulling in grpc and protobuf
+type GRPCChecker struct{}
+
+func init() {
+	Register(&GRPCChecker{})
+}
+
+const (
+	grpcHealthPath   = "/grpc.health.v1.Health/Check"
+	grpcMaxMessage   = 64 * 1024
+	grpcStatusOK     = 0
+	grpcServing      = 1
+	grpcUnauthorized = 16 // UNAUTHENTICATED
+	grpcDenied       = 7  // PERMISSION_DENIED
+)
+
+// HealthCheckResponse.ServingStatus names
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+func (c *GRPCChecker) Name() string {
+	return "gRPC health"
+}
+
+func (c *GRPCChecker) Prefix() string {
+	return "GRPC"
+}
+
+func (c *GRPCChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *GRPCChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "ADDRESS", Required: true},
+		{Name: "PORT", Required: true},
+		{Name: "SERVICE"},
+	}, TLSFields...)
+}
+
+func (c *GRPCChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	if err := c.check(ctx, host); err != nil {
+		res = res.Fail(err)
+	} else {
+		res = res.Pass(fmt.Sprintf("health check for service %q is SERVING", host["SERVICE"]))
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (c *GRPCChecker) check(ctx context.Context, host map[string]string) error {
+	useTLS, err := TLSEnabled(host)
+	if err != nil {
+		return err
+	}
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	transport := &http2.Transport{}
+	scheme := "https"
+	if useTLS {
+		cfg, err := TLSConfig(host)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = cfg
+	} else {
+		// h2c: the transport insists on calling DialTLS, so hand it a plain connection
+		scheme = "http"
+		transport.AllowHTTP = true
+		transport.DialTLS = func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequest(http.MethodPost, scheme+"://"+target+grpcHealthPath,
+		bytes.NewReader(grpcFrame(grpcHealthRequest(host["SERVICE"]))))
+	if err != nil {
+		return fmt.Errorf("unable to build the request: %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("User-Agent", "preflight")
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		kind := ErrNetwork
+		if IsTLSError(err) {
+			kind = ErrTLS
+		}
+		return fmt.Errorf("%w: health check call to %s failed: %v", kind, target, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered the health check with HTTP %s", ErrProtocol, target, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, grpcMaxMessage))
+	if err != nil {
+		return fmt.Errorf("%w: reading the health check response: %v", ErrNetwork, err)
+	}
+	// errors come back in the trailers, or in the headers for a response without a body
+	if err := grpcError(resp); err != nil {
+		return err
+	}
+
+	msg, err := grpcUnframe(body)
+	if err != nil {
+		return err
+	}
+	status := grpcHealthStatus(msg)
+	if status != grpcServing {
+		name, ok := grpcServingStatus[status]
+		if !ok {
+			name = strconv.FormatUint(status, 10)
+		}
+		return fmt.Errorf("%w: service %q is %s", ErrRejected, host["SERVICE"], name)
+	}
+	return nil
+}
+
+// grpcError turns a non-OK grpc-status into an error
+func grpcError(resp *http.Response) error {
+	code := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if code == "" {
+		return fmt.Errorf("%w: response has no grpc-status", ErrProtocol)
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return fmt.Errorf("%w: bad grpc-status %q", ErrProtocol, code)
+	}
+	switch n {
+	case grpcStatusOK:
+		return nil
+	case grpcUnauthorized, grpcDenied:
+		return fmt.Errorf("%w: grpc-status %d: %s", ErrAuth, n, message)
+	}
+	// UNIMPLEMENTED (12) means the server has no health service, NOT_FOUND (5) means it doesn't know SERVICE
+	return fmt.Errorf("%w: grpc-status %d: %s", ErrRejected, n, message)
+}
+
+// grpcHealthRequest encodes HealthCheckRequest{string service = 1}
+func grpcHealthRequest(service string) []byte {
+	if service == "" {
+		return nil
+	}
+	msg := []byte{0x0a}
+	msg = append(msg, grpcVarint(uint64(len(service)))...)
+	return append(msg, service...)
+}
+
+// grpcHealthStatus decodes the status field from HealthCheckResponse{ServingStatus status = 1}. A missing field is
+// the zero value, UNKNOWN
+func grpcHealthStatus(msg []byte) uint64 {
+	var status uint64
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			break
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return status
+			}
+			if key>>3 == 1 {
+				status = v
+			}
+			msg = msg[n:]
+		case 2: // length delimited
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return status
+			}
+			msg = msg[n+int(l):]
+		default:
+			return status
+		}
+	}
+	return status
+}
+
+func grpcVarint(v uint64) []byte {
+	b := make([]byte, binary.MaxVarintLen64)
+	return b[:binary.PutUvarint(b, v)]
+}
+
+// grpcFrame adds the length prefix: a compressed flag byte, then the message length as four bytes
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func grpcUnframe(body []byte) ([]byte, error) {
+	if len(body) < 5 {
+		return nil, fmt.Errorf("%w: health check response is too short", ErrProtocol)
+	}
+	if body[0] != 0 {
+		return nil, fmt.Errorf("%w: health check response is compressed", ErrProtocol)
+	}
+	length := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < length {
+		return nil, fmt.Errorf("%w: health check response is truncated", ErrProtocol)
+	}
+	return body[5 : 5+length], nil
+}
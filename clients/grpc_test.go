@@ -0,0 +1,127 @@
+package clients
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// fakeHealth is a grpc.health.v1.Health server. services maps service names to their serving status. Unknown
+// services get NOT_FOUND like the real implementation
+func fakeHealth(services map[string]uint64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthPath || r.Header.Get("Content-Type") != "application/grpc" {
+			w.Header().Set("Grpc-Status", "12")
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		msg, err := grpcUnframe(body)
+		if err != nil {
+			w.Header().Set("Grpc-Status", "13")
+			return
+		}
+		// the request is {1: service}, so everything after the tag and length is the name
+		service := ""
+		if len(msg) > 2 {
+			service = string(msg[2:])
+		}
+		status, ok := services[service]
+		if !ok {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = w.Write(grpcFrame(append([]byte{0x08}, grpcVarint(status)...)))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+var fakeServices = map[string]uint64{"": grpcServing, "billing": grpcServing, "payments": 2}
+
+func grpcHost(srv *httptest.Server, fields map[string]string) map[string]string {
+	addr := srv.Listener.Addr().(*net.TCPAddr)
+	host := map[string]string{"ID": "BILLING", "CLIENT": "GRPC", "ADDRESS": "127.0.0.1", "PORT": strconv.Itoa(addr.Port)}
+	for k, v := range fields {
+		host[k] = v
+	}
+	return host
+}
+
+func TestGRPCChecker(t *testing.T) {
+	srv := httptest.NewServer(h2c.NewHandler(fakeHealth(fakeServices), &http2.Server{}))
+	defer srv.Close()
+
+	cases := []struct {
+		service string
+		kind    error
+	}{
+		{"", nil},
+		{"billing", nil},
+		{"payments", ErrRejected},
+		{"shipping", ErrRejected},
+	}
+	for _, c := range cases {
+		res := runChecker(&GRPCChecker{}, grpcHost(srv, map[string]string{"SERVICE": c.service}))
+		if c.kind == nil && !res.OK {
+			t.Errorf("%q: expected the health check to pass: %s", c.service, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%q: expected %v, got %v", c.service, c.kind, res.Err)
+		}
+	}
+}
+
+// a port that's open but doesn't speak gRPC still fails
+func TestGRPCCheckerNotGRPC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	res := runChecker(&GRPCChecker{}, grpcHost(srv, nil))
+	if res.OK {
+		t.Error("expected the health check against a plain http server to fail")
+	}
+}
+
+func TestGRPCCheckerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	srv := httptest.NewUnstartedServer(fakeHealth(fakeServices))
+	srv.TLS = ca.serverConfig(t)
+	srv.TLS.NextProtos = []string{"h2"}
+	if err := http2.ConfigureServer(srv.Config, nil); err != nil {
+		t.Fatal(err)
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	res := runChecker(&GRPCChecker{}, grpcHost(srv, map[string]string{"SERVICE": "billing", "TLS": "true", "CAFILE": ca.caFile, "ADDRESS": "localhost"}))
+	if !res.OK {
+		t.Errorf("expected the health check over TLS to pass: %s", res.Message)
+	}
+	res = runChecker(&GRPCChecker{}, grpcHost(srv, map[string]string{"SERVICE": "billing", "TLS": "true", "ADDRESS": "localhost"}))
+	if res.OK || !errors.Is(res.Err, ErrTLS) {
+		t.Errorf("expected the TLS handshake to fail: %v", res.Err)
+	}
+}
+
+func TestGRPCHealthStatus(t *testing.T) {
+	cases := map[string]uint64{
+		"":                    0,
+		"\x08\x01":            1,
+		"\x08\x02":            2,
+		"\x12\x03abc\x08\x01": 1, // unknown fields are skipped
+	}
+	for msg, want := range cases {
+		if got := grpcHealthStatus([]byte(msg)); got != want {
+			t.Errorf("%q: expected %d, got %d", msg, want, got)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPChecker sends a request to the host's URL and checks the response. The host map fields are:
+//
+//	URL=https://billing.domain.com/health   required. http and https are supported
+//	METHOD=HEAD                             defaults to GET
+//	EXPECTSTATUS=200,204                    accepted status codes. "2xx" style classes work too. defaults to 2xx
+//	EXPECTBODY=regex                        the response body has to match the regular expression
+//	EXPECTHEADERS=X-Version,Content-Type=application/json
+//	                                        response headers that have to be set, optionally to an exact value
+//	TOKEN=secret                            sent as a bearer token
+//	USERNAME=name, PASSWORD=secret          sent as basic auth
+//
+// https URLs use SERVERNAME and CAFILE like the other clients, see tls.go
+type HTTPChecker struct{}
+
+func init() {
+	Register(&HTTPChecker{})
+}
+
+// only this much of the body is read to match EXPECTBODY
+const httpMaxBody = 1 << 20
+
+func (c *HTTPChecker) Name() string {
+	return "HTTP"
+}
+
+func (c *HTTPChecker) Prefix() string {
+	return "HTTP"
+}
+
+func (c *HTTPChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *HTTPChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "URL", Required: true},
+		{Name: "METHOD"},
+		{Name: "EXPECTSTATUS"},
+		{Name: "EXPECTBODY"},
+		{Name: "EXPECTHEADERS"},
+		{Name: "TOKEN"},
+		{Name: "USERNAME"},
+		{Name: "PASSWORD"},
+	}, TLSFields...)
+}
+
+// Endpoints returns the host and port from the URL so the resolve and tcp checks work without ADDRESS and PORT
+func (c *HTTPChecker) Endpoints(host map[string]string) ([]Endpoint, error) {
+	u, err := httpURL(host)
+	if err != nil {
+		return nil, err
+	}
+	return []Endpoint{{Address: u.Hostname(), Port: httpPort(u)}}, nil
+}
+
+func (c *HTTPChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	status, err := c.request(ctx, host)
+	if err != nil {
+		res = res.Fail(err)
+	} else {
+		res = res.Pass(fmt.Sprintf("%s %s returned %s", httpMethod(host), host["URL"], status))
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// request sends the request and checks the response against the EXPECT fields. It returns the response status
+func (c *HTTPChecker) request(ctx context.Context, host map[string]string) (string, error) {
+	u, err := httpURL(host)
+	if err != nil {
+		return "", err
+	}
+	var bodyPattern *regexp.Regexp
+	if host["EXPECTBODY"] != "" {
+		bodyPattern, err = regexp.Compile(host["EXPECTBODY"])
+		if err != nil {
+			return "", fmt.Errorf("EXPECTBODY isn't a valid regular expression: %v", err)
+		}
+	}
+	// catch a bad EXPECTSTATUS before sending anything
+	if _, err := httpStatusExpected(0, host["EXPECTSTATUS"]); err != nil {
+		return "", err
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if u.Scheme == "https" {
+		cfg, err := TLSConfig(host)
+		if err != nil {
+			return "", err
+		}
+		if host["SERVERNAME"] == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		transport.TLSClientConfig = cfg
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest(httpMethod(host), u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to build the request: %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", "preflight")
+	if host["TOKEN"] != "" {
+		req.Header.Set("Authorization", "Bearer "+host["TOKEN"])
+	} else if host["USERNAME"] != "" {
+		req.SetBasicAuth(host["USERNAME"], host["PASSWORD"])
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		kind := ErrNetwork
+		if IsTLSError(err) {
+			kind = ErrTLS
+		}
+		return "", fmt.Errorf("%w: %s %s failed: %v", kind, req.Method, host["URL"], err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	ok, err := httpStatusExpected(resp.StatusCode, host["EXPECTSTATUS"])
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		kind := ErrRejected
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			kind = ErrAuth
+		}
+		return "", fmt.Errorf("%w: %s %s returned %s", kind, req.Method, host["URL"], resp.Status)
+	}
+	if err := httpHeadersExpected(resp.Header, host["EXPECTHEADERS"]); err != nil {
+		return "", err
+	}
+	if bodyPattern != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpMaxBody))
+		if err != nil {
+			return "", fmt.Errorf("%w: reading the response body: %v", ErrNetwork, err)
+		}
+		if !bodyPattern.Match(body) {
+			return "", fmt.Errorf("%w: response body doesn't match %q", ErrRejected, host["EXPECTBODY"])
+		}
+	}
+	return resp.Status, nil
+}
+
+func httpURL(host map[string]string) (*url.URL, error) {
+	u, err := url.Parse(host["URL"])
+	if err != nil {
+		return nil, fmt.Errorf("URL isn't valid: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("URL must start with http:// or https://, not %q", host["URL"])
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("URL has no host: %q", host["URL"])
+	}
+	return u, nil
+}
+
+func httpPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Port()
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func httpMethod(host map[string]string) string {
+	if host["METHOD"] == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(host["METHOD"])
+}
+
+// httpStatusExpected checks a status code against a list like "200,204" or "2xx,304". An empty list means any 2xx
+func httpStatusExpected(code int, expect string) (bool, error) {
+	if expect == "" {
+		expect = "2xx"
+	}
+	for _, s := range strings.Split(expect, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if len(s) == 3 && strings.HasSuffix(s, "xx") && s[0] >= '1' && s[0] <= '5' {
+			if code/100 == int(s[0]-'0') {
+				return true, nil
+			}
+			continue
+		}
+		want, err := strconv.Atoi(s)
+		if err != nil {
+			return false, fmt.Errorf("EXPECTSTATUS must be a list of status codes like 200,204 or 2xx, not %q", expect)
+		}
+		if code == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// httpHeadersExpected checks a list like "X-Version,Content-Type=application/json". A bare name only has to be set
+func httpHeadersExpected(headers http.Header, expect string) error {
+	if expect == "" {
+		return nil
+	}
+	for _, h := range strings.Split(expect, ",") {
+		name, want := strings.TrimSpace(h), ""
+		hasValue := false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, want, hasValue = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:]), true
+		}
+		if name == "" {
+			continue
+		}
+		got, set := headers[http.CanonicalHeaderKey(name)]
+		if !set {
+			return fmt.Errorf("%w: response has no %s header", ErrRejected, name)
+		}
+		if hasValue && (len(got) == 0 || got[0] != want) {
+			return fmt.Errorf("%w: response header %s is %q, expected %q", ErrRejected, name, strings.Join(got, ","), want)
+		}
+	}
+	return nil
+}
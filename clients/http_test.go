@@ -0,0 +1,141 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// a stand-in for an internal API. /health wants a bearer token, /basic wants basic auth
+func newFakeAPI() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer goodtoken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Version", "1.2.3")
+		_, _ = fmt.Fprint(w, `{"status": "ok"}`)
+	})
+	mux.HandleFunc("/basic", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "pat" || pass != "goodpassword" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	return httptest.NewServer(mux)
+}
+
+func runHTTP(fields map[string]string) Result {
+	host := map[string]string{"ID": "BILLING_API", "CLIENT": "HTTP"}
+	for k, v := range fields {
+		host[k] = v
+	}
+	return runChecker(&HTTPChecker{}, host)
+}
+
+func TestHTTPChecker(t *testing.T) {
+	srv := newFakeAPI()
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		fields map[string]string
+		kind   error
+	}{
+		{"token", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken"}, nil},
+		{"body and headers", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken",
+			"EXPECTBODY": `"status":\s*"ok"`, "EXPECTHEADERS": "X-Version, content-type=application/json"}, nil},
+		{"basic auth", map[string]string{"URL": srv.URL + "/basic", "USERNAME": "pat", "PASSWORD": "goodpassword",
+			"EXPECTSTATUS": "204"}, nil},
+		{"expected error status", map[string]string{"URL": srv.URL + "/broken", "EXPECTSTATUS": "200,5xx"}, nil},
+		{"bad token", map[string]string{"URL": srv.URL + "/health", "TOKEN": "oldtoken"}, ErrAuth},
+		{"bad password", map[string]string{"URL": srv.URL + "/basic", "USERNAME": "pat", "PASSWORD": "old"}, ErrAuth},
+		{"server error", map[string]string{"URL": srv.URL + "/broken"}, ErrRejected},
+		{"wrong status", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken", "EXPECTSTATUS": "201"}, ErrRejected},
+		{"body mismatch", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken", "EXPECTBODY": "degraded"}, ErrRejected},
+		{"missing header", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken", "EXPECTHEADERS": "X-Region"}, ErrRejected},
+		{"wrong header value", map[string]string{"URL": srv.URL + "/health", "TOKEN": "goodtoken", "EXPECTHEADERS": "X-Version=2.0.0"}, ErrRejected},
+	}
+	for _, c := range cases {
+		res := runHTTP(c.fields)
+		if c.kind == nil && !res.OK {
+			t.Errorf("%s: expected the request to pass: %s", c.name, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.kind, res.Err)
+		}
+	}
+}
+
+func TestHTTPCheckerMethod(t *testing.T) {
+	var method string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	}))
+	defer srv.Close()
+
+	res := runHTTP(map[string]string{"URL": srv.URL, "METHOD": "head"})
+	if !res.OK || method != http.MethodHead {
+		t.Errorf("expected a HEAD request to pass, got %s: %s", method, res.Message)
+	}
+}
+
+func TestHTTPCheckerConfigErrors(t *testing.T) {
+	for _, fields := range []map[string]string{
+		{"URL": "ftp://files.domain.com"},
+		{"URL": "http://127.0.0.1:1", "EXPECTBODY": "("},
+		{"URL": "http://127.0.0.1:1", "EXPECTSTATUS": "ok"},
+	} {
+		res := runHTTP(fields)
+		if res.OK || errors.Is(res.Err, ErrNetwork) {
+			t.Errorf("%v is a config error, not %v", fields, res.Err)
+		}
+	}
+}
+
+func TestHTTPCheckerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = ca.serverConfig(t)
+	srv.StartTLS()
+	defer srv.Close()
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	res := runHTTP(map[string]string{"URL": url, "CAFILE": ca.caFile})
+	if !res.OK {
+		t.Errorf("expected the https request to pass: %s", res.Message)
+	}
+	res = runHTTP(map[string]string{"URL": url})
+	if res.OK || !errors.Is(res.Err, ErrTLS) {
+		t.Errorf("expected certificate verification to fail: %v", res.Err)
+	}
+}
+
+func TestHTTPCheckerEndpoints(t *testing.T) {
+	cases := map[string]Endpoint{
+		"http://billing.domain.com/health":  {"billing.domain.com", "80"},
+		"https://billing.domain.com/health": {"billing.domain.com", "443"},
+		"https://billing.domain.com:8443/":  {"billing.domain.com", "8443"},
+		"http://[::1]:8080/health":          {"::1", "8080"},
+	}
+	for u, want := range cases {
+		got, err := Endpoints(map[string]string{"ID": "BILLING_API", "CLIENT": "HTTP", "URL": u})
+		if err != nil || len(got) != 1 || got[0] != want {
+			t.Errorf("%s: expected %v, got %v %v", u, want, got, err)
+		}
+	}
+	if _, err := Endpoints(map[string]string{"CLIENT": "HTTP", "URL": "billing.domain.com"}); err == nil {
+		t.Error("expected a URL without a scheme to be rejected")
+	}
+}
@@ -0,0 +1,464 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// KafkaChecker fetches cluster metadata from the host's BROKERS and makes sure every topic in TOPICS exists. Services
+// tend to crash-loop when a topic wasn't created in a new environment, and a tcp connect to the broker can't tell.
+// The host map fields are:
+//
+//	BROKERS=kafka1.domain.com:9092,kafka2.domain.com:9092   required. the port defaults to 9092
+//	TOPICS=orders,payments                                  topics that have to exist
+//	SASLMECHANISM=SCRAM-SHA-512                             PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512 with USERNAME and
+//	                                                        PASSWORD. leave it unset for brokers without SASL
+//
+// TLS=true connects with TLS, see tls.go. Like any Kafka client, the first broker that accepts a connection is asked
+// for the metadata. The tcp check already made sure every broker is reachable
+type KafkaChecker struct{}
+
+func init() {
+	Register(&KafkaChecker{})
+}
+
+const (
+	kafkaDefaultPort     = "9092"
+	kafkaMaxResponseSize = 16 << 20
+	kafkaClientID        = "preflight"
+
+	kafkaAPIMetadata         int16 = 3
+	kafkaAPISaslHandshake    int16 = 17
+	kafkaAPISaslAuthenticate int16 = 36
+
+	kafkaUnknownTopic         int16 = 3
+	kafkaTopicAuthFailed      int16 = 29
+	kafkaUnsupportedMechanism int16 = 33
+	kafkaSaslAuthFailed       int16 = 58
+)
+
+// error code names for the errors the checks can run into
+var kafkaErrorNames = map[int16]string{
+	kafkaUnknownTopic:         "UNKNOWN_TOPIC_OR_PARTITION",
+	5:                         "LEADER_NOT_AVAILABLE",
+	kafkaTopicAuthFailed:      "TOPIC_AUTHORIZATION_FAILED",
+	31:                        "CLUSTER_AUTHORIZATION_FAILED",
+	kafkaUnsupportedMechanism: "UNSUPPORTED_SASL_MECHANISM",
+	34:                        "ILLEGAL_SASL_STATE",
+	35:                        "UNSUPPORTED_VERSION",
+	kafkaSaslAuthFailed:       "SASL_AUTHENTICATION_FAILED",
+}
+
+var kafkaScramHashes = map[string]func() hash.Hash{
+	"SCRAM-SHA-256": sha256.New,
+	"SCRAM-SHA-512": sha512.New,
+}
+
+func (c *KafkaChecker) Name() string {
+	return "Kafka"
+}
+
+func (c *KafkaChecker) Prefix() string {
+	return "KAFKA"
+}
+
+func (c *KafkaChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *KafkaChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "BROKERS", Required: true},
+		{Name: "TOPICS"},
+		{Name: "SASLMECHANISM"},
+		{Name: "USERNAME"},
+		{Name: "PASSWORD"},
+	}, TLSFields...)
+}
+
+// Endpoints returns every broker in BROKERS
+func (c *KafkaChecker) Endpoints(host map[string]string) ([]Endpoint, error) {
+	var res []Endpoint
+	for _, broker := range splitList(host["BROKERS"]) {
+		address, port, err := net.SplitHostPort(broker)
+		if err != nil {
+			// no port, or a bare IPv6 address
+			address, port = strings.Trim(broker, "[]"), kafkaDefaultPort
+		}
+		res = append(res, Endpoint{Address: address, Port: port})
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("BROKERS has no brokers: %q", host["BROKERS"])
+	}
+	return res, nil
+}
+
+func (c *KafkaChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	meta, err := c.metadata(ctx, host)
+	if err != nil {
+		res = res.Fail(err)
+	} else {
+		msg := fmt.Sprintf("cluster %s has %d brokers", meta.clusterID, meta.brokers)
+		if topics := splitList(host["TOPICS"]); len(topics) > 0 {
+			msg += fmt.Sprintf(", found topics %s", strings.Join(topics, ", "))
+		}
+		res = res.Pass(msg)
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+type kafkaMetadata struct {
+	clusterID string
+	brokers   int
+	// topic name -> error code
+	topics map[string]int16
+}
+
+// metadata logs in to the first broker that accepts a connection and checks the topics against its metadata
+func (c *KafkaChecker) metadata(ctx context.Context, host map[string]string) (*kafkaMetadata, error) {
+	mechanism := strings.ToUpper(host["SASLMECHANISM"])
+	if mechanism != "" && mechanism != "PLAIN" && kafkaScramHashes[mechanism] == nil {
+		return nil, fmt.Errorf("SASLMECHANISM must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512, not %q", host["SASLMECHANISM"])
+	}
+	useTLS, err := TLSEnabled(host)
+	if err != nil {
+		return nil, err
+	}
+	brokers, err := c.Endpoints(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	for _, broker := range brokers {
+		if conn, err = kafkaDial(ctx, host, broker, useTLS); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	k := &kafkaConn{conn: conn}
+	if mechanism != "" {
+		if err := k.saslHandshake(mechanism); err != nil {
+			return nil, err
+		}
+		if err := k.saslAuthenticate(mechanism, host["USERNAME"], host["PASSWORD"]); err != nil {
+			return nil, err
+		}
+	}
+	topics := splitList(host["TOPICS"])
+	meta, err := k.metadata(topics)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, topic := range topics {
+		switch code := meta.topics[topic]; code {
+		case 0:
+		case kafkaUnknownTopic:
+			missing = append(missing, topic)
+		case kafkaTopicAuthFailed:
+			return nil, fmt.Errorf("%w: not authorized to describe topic %s", ErrAuth, topic)
+		default:
+			return nil, fmt.Errorf("%w: topic %s: %s", ErrRejected, topic, kafkaErrorName(code))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: topics don't exist: %s", ErrRejected, strings.Join(missing, ", "))
+	}
+	return meta, nil
+}
+
+func kafkaDial(ctx context.Context, host map[string]string, broker Endpoint, useTLS bool) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", broker.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, broker, err)
+	}
+	if !useTLS {
+		return conn, nil
+	}
+	cfg, err := TLSConfig(host)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if host["SERVERNAME"] == "" {
+		cfg.ServerName = broker.Address
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	tlsConn, err := Handshake(conn, cfg, broker.String())
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// kafkaConn sends requests with a v1 request header: size, api key, api version, correlation id and client id.
+// Responses start with their size and the correlation id
+type kafkaConn struct {
+	conn          net.Conn
+	correlationID int32
+}
+
+func (k *kafkaConn) request(apiKey, apiVersion int16, body []byte) (*kafkaReader, error) {
+	k.correlationID++
+	var w kafkaWriter
+	w.int16(apiKey)
+	w.int16(apiVersion)
+	w.int32(k.correlationID)
+	w.string(kafkaClientID)
+	w.buf.Write(body)
+	msg := append(make([]byte, 4), w.buf.Bytes()...)
+	binary.BigEndian.PutUint32(msg, uint32(w.buf.Len()))
+	if _, err := k.conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("%w: writing to broker: %v", ErrNetwork, err)
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(k.conn, header); err != nil {
+		return nil, fmt.Errorf("%w: reading from broker: %v", ErrNetwork, err)
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("%w: bad response size %d", ErrProtocol, size)
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != k.correlationID {
+		return nil, fmt.Errorf("%w: response for request %d, expected %d", ErrProtocol, id, k.correlationID)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(k.conn, resp); err != nil {
+		return nil, fmt.Errorf("%w: reading from broker: %v", ErrNetwork, err)
+	}
+	return &kafkaReader{buf: resp}, nil
+}
+
+// saslHandshake picks the mechanism. v1 means the tokens that follow are wrapped in SaslAuthenticate requests
+func (k *kafkaConn) saslHandshake(mechanism string) error {
+	var w kafkaWriter
+	w.string(mechanism)
+	r, err := k.request(kafkaAPISaslHandshake, 1, w.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	code := r.int16()
+	var enabled []string
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		enabled = append(enabled, r.string())
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if code == kafkaUnsupportedMechanism {
+		return fmt.Errorf("%w: broker doesn't enable %s. enabled mechanisms: %s", ErrRejected, mechanism,
+			strings.Join(enabled, ", "))
+	}
+	if code != 0 {
+		return fmt.Errorf("%w: SASL handshake failed: %s", ErrRejected, kafkaErrorName(code))
+	}
+	return nil
+}
+
+func (k *kafkaConn) saslAuthenticate(mechanism, username, password string) error {
+	if mechanism == "PLAIN" {
+		_, err := k.saslToken([]byte("\x00" + username + "\x00" + password))
+		return err
+	}
+	client := newScramClient(kafkaScramHashes[mechanism], username, password)
+	serverFirst, err := k.saslToken([]byte(client.ClientFirst()))
+	if err != nil {
+		return err
+	}
+	final, err := client.ClientFinal(string(serverFirst))
+	if err != nil {
+		return err
+	}
+	serverFinal, err := k.saslToken([]byte(final))
+	if err != nil {
+		return err
+	}
+	return client.VerifyServerFinal(string(serverFinal))
+}
+
+// saslToken sends one SaslAuthenticate v0 request and returns the server's token
+func (k *kafkaConn) saslToken(token []byte) ([]byte, error) {
+	var w kafkaWriter
+	w.bytes(token)
+	r, err := k.request(kafkaAPISaslAuthenticate, 0, w.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	code := r.int16()
+	message := r.string()
+	reply := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if code == kafkaSaslAuthFailed {
+		return nil, fmt.Errorf("%w: %s", ErrAuth, message)
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("%w: SASL authentication failed: %s %s", ErrRejected, kafkaErrorName(code), message)
+	}
+	return reply, nil
+}
+
+// metadata sends a Metadata v4 request for the topics. v4 is the first version that can ask the broker not to create
+// topics that don't exist, which would hide exactly the problem this check is looking for
+func (k *kafkaConn) metadata(topics []string) (*kafkaMetadata, error) {
+	var w kafkaWriter
+	w.int32(int32(len(topics)))
+	for _, topic := range topics {
+		w.string(topic)
+	}
+	w.buf.WriteByte(0) // allow_auto_topic_creation
+	r, err := k.request(kafkaAPIMetadata, 4, w.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &kafkaMetadata{topics: make(map[string]int16)}
+	r.int32() // throttle_time_ms
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.int32()  // node_id
+		r.string() // host
+		r.int32()  // port
+		r.string() // rack
+		meta.brokers++
+	}
+	meta.clusterID = r.string()
+	r.int32() // controller_id
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		code := r.int16()
+		name := r.string()
+		r.take(1) // is_internal
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			r.int16() // error_code
+			r.int32() // partition_index
+			r.int32() // leader_id
+			r.take(4 * int(r.int32()))
+			r.take(4 * int(r.int32()))
+		}
+		meta.topics[name] = code
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if meta.clusterID == "" {
+		meta.clusterID = "unknown"
+	}
+	return meta, nil
+}
+
+func kafkaErrorName(code int16) string {
+	if name, ok := kafkaErrorNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("error code %d", code)
+}
+
+type kafkaWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *kafkaWriter) int16(v int16) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.buf.Write(b)
+}
+
+// kafkaReader decodes a response. The first short read sets err and every read after it returns zero values, so a
+// whole response can be decoded before checking err once
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.buf) {
+		r.err = fmt.Errorf("%w: response is truncated", ErrProtocol)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+// string reads a nullable string. null comes back empty
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.take(int(n)))
+}
+
+func (r *kafkaReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return r.take(int(n))
+}
+
+// split a comma separated field into its trimmed, non-empty entries
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
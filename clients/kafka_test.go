@@ -0,0 +1,208 @@
+package clients
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeKafka is a single broker with SASL/PLAIN and SCRAM-SHA-512 enabled when sasl is set. Metadata requests are
+// refused until the client authenticates
+type fakeKafka struct {
+	*fakeServer
+	sasl   bool
+	topics map[string]bool
+}
+
+func startFakeKafka(t *testing.T, sasl bool) *fakeKafka {
+	f := &fakeKafka{sasl: sasl, topics: map[string]bool{"orders": true, "payments": true}}
+	f.fakeServer = startFakeServer(t, f.serve)
+	return f
+}
+
+func (f *fakeKafka) host(fields map[string]string) map[string]string {
+	host := map[string]string{"ID": "EVENTS", "CLIENT": "KAFKA", "BROKERS": f.addr()}
+	for k, v := range fields {
+		host[k] = v
+	}
+	return host
+}
+
+func (f *fakeKafka) serve(conn net.Conn) {
+	authenticated := !f.sasl
+	var mechanism string
+	var scram *scramServer
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		r := &kafkaReader{buf: req}
+		apiKey, _, correlationID := r.int16(), r.int16(), r.int32()
+		r.string() // client id
+
+		var w kafkaWriter
+		w.int32(correlationID)
+		switch apiKey {
+		case kafkaAPISaslHandshake:
+			mechanism = r.string()
+			if !f.sasl || (mechanism != "PLAIN" && mechanism != "SCRAM-SHA-512") {
+				w.int16(kafkaUnsupportedMechanism)
+			} else {
+				w.int16(0)
+			}
+			w.int32(2)
+			w.string("PLAIN")
+			w.string("SCRAM-SHA-512")
+		case kafkaAPISaslAuthenticate:
+			token := string(r.bytes())
+			code, reply := int16(0), ""
+			switch {
+			case mechanism == "PLAIN":
+				if token != "\x00events\x00goodpassword" {
+					code = kafkaSaslAuthFailed
+				}
+				authenticated = code == 0
+			case scram == nil:
+				scram = newScramServer(sha512.New, "goodpassword")
+				reply = scram.First(token)
+			default:
+				var ok bool
+				if reply, ok = scram.Final(token); !ok {
+					code = kafkaSaslAuthFailed
+				}
+				authenticated = ok
+			}
+			w.int16(code)
+			if code != 0 {
+				w.string("Authentication failed: Invalid username or password")
+				reply = ""
+			} else {
+				w.int16(-1)
+			}
+			w.bytes([]byte(reply))
+		case kafkaAPIMetadata:
+			if !authenticated {
+				return
+			}
+			w.int32(0) // throttle
+			w.int32(1)
+			w.int32(1)
+			w.string("127.0.0.1")
+			w.int32(9092)
+			w.int16(-1) // rack
+			w.string("fake-cluster")
+			w.int32(1) // controller
+			n := r.int32()
+			w.int32(n)
+			for ; n > 0; n-- {
+				name := r.string()
+				if f.topics[name] {
+					w.int16(0)
+				} else {
+					w.int16(kafkaUnknownTopic)
+				}
+				w.string(name)
+				w.buf.WriteByte(0)
+				w.int32(1) // one partition, led by broker 1
+				w.int16(0)
+				w.int32(0)
+				w.int32(1)
+				w.int32(1)
+				w.int32(1)
+				w.int32(1)
+				w.int32(1)
+			}
+		default:
+			return
+		}
+		resp := make([]byte, 4)
+		binary.BigEndian.PutUint32(resp, uint32(w.buf.Len()))
+		if _, err := conn.Write(append(resp, w.buf.Bytes()...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestKafkaCheckerTopics(t *testing.T) {
+	f := startFakeKafka(t, false)
+	defer f.Close()
+
+	res := runChecker(&KafkaChecker{}, f.host(map[string]string{"TOPICS": "orders, payments"}))
+	if !res.OK || !strings.Contains(res.Message, "fake-cluster") {
+		t.Errorf("expected the topics to be found: %s", res.Message)
+	}
+	res = runChecker(&KafkaChecker{}, f.host(map[string]string{"TOPICS": "orders,refunds,returns"}))
+	if res.OK || !errors.Is(res.Err, ErrRejected) || !strings.Contains(res.Message, "refunds, returns") {
+		t.Errorf("expected the missing topics to be reported: %v", res.Err)
+	}
+}
+
+func TestKafkaCheckerSASL(t *testing.T) {
+	f := startFakeKafka(t, true)
+	defer f.Close()
+
+	cases := []struct {
+		name   string
+		fields map[string]string
+		kind   error
+	}{
+		{"plain", map[string]string{"SASLMECHANISM": "PLAIN", "USERNAME": "events", "PASSWORD": "goodpassword"}, nil},
+		{"scram", map[string]string{"SASLMECHANISM": "scram-sha-512", "USERNAME": "events", "PASSWORD": "goodpassword"}, nil},
+		{"plain bad password", map[string]string{"SASLMECHANISM": "PLAIN", "USERNAME": "events", "PASSWORD": "old"}, ErrAuth},
+		{"scram bad password", map[string]string{"SASLMECHANISM": "SCRAM-SHA-512", "USERNAME": "events", "PASSWORD": "old"}, ErrAuth},
+		{"mechanism not enabled", map[string]string{"SASLMECHANISM": "SCRAM-SHA-256", "USERNAME": "events", "PASSWORD": "goodpassword"}, ErrRejected},
+	}
+	for _, c := range cases {
+		c.fields["TOPICS"] = "orders"
+		res := runChecker(&KafkaChecker{}, f.host(c.fields))
+		if c.kind == nil && !res.OK {
+			t.Errorf("%s: expected the check to pass: %s", c.name, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.kind, res.Err)
+		}
+	}
+}
+
+// the first broker that accepts a connection answers for the cluster
+func TestKafkaCheckerBrokerFailover(t *testing.T) {
+	f := startFakeKafka(t, false)
+	defer f.Close()
+
+	res := runChecker(&KafkaChecker{}, f.host(map[string]string{"BROKERS": "127.0.0.1:1," + f.ln.Addr().String(), "TOPICS": "orders"}))
+	if !res.OK {
+		t.Errorf("expected the second broker to answer: %s", res.Message)
+	}
+}
+
+func TestKafkaCheckerBadMechanism(t *testing.T) {
+	res := runChecker(&KafkaChecker{}, map[string]string{"ID": "EVENTS", "CLIENT": "KAFKA", "BROKERS": "127.0.0.1:1", "SASLMECHANISM": "GSSAPI"})
+	if res.OK || errors.Is(res.Err, ErrNetwork) {
+		t.Errorf("an unsupported SASLMECHANISM is a config error, not %v", res.Err)
+	}
+}
+
+func TestKafkaCheckerEndpoints(t *testing.T) {
+	got, err := (&KafkaChecker{}).Endpoints(map[string]string{"BROKERS": "kafka1.domain.com:9093, kafka2.domain.com,[::1]:9094"})
+	want := []Endpoint{{"kafka1.domain.com", "9093"}, {"kafka2.domain.com", "9092"}, {"::1", "9094"}}
+	if err != nil || len(got) != len(want) {
+		t.Fatalf("expected %v, got %v %v", want, got, err)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+	if _, err := (&KafkaChecker{}).Endpoints(map[string]string{"BROKERS": " , "}); err == nil {
+		t.Error("expected an empty broker list to be rejected")
+	}
+}
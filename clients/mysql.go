@@ -0,0 +1,351 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// MySQLChecker logs in to a MySQL or MariaDB server with the host's USERNAME and PASSWORD. It reads the server's
+// initial handshake, answers with mysql_native_password or caching_sha2_password (including auth switch requests and
+// the RSA public key exchange caching_sha2_password needs on its full auth path), then disconnects. DATABASE is
+// optional
+type MySQLChecker struct{}
+
+func init() {
+	Register(&MySQLChecker{})
+}
+
+const (
+	mysqlClientLongPassword     uint32 = 0x00000001
+	mysqlClientConnectWithDB    uint32 = 0x00000008
+	mysqlClientProtocol41       uint32 = 0x00000200
+	mysqlClientSecureConnection uint32 = 0x00008000
+	mysqlClientPluginAuth       uint32 = 0x00080000
+
+	mysqlCharsetUTF8MB4 byte = 45
+
+	mysqlNativePassword      = "mysql_native_password"
+	mysqlCachingSHA2         = "caching_sha2_password"
+	mysqlRequestKey     byte = 0x02
+
+	// error codes that mean the credentials were wrong, rather than what they were used for
+	mysqlErrAccessDenied uint16 = 1045
+)
+
+func (c *MySQLChecker) Name() string {
+	return "MySQL"
+}
+
+func (c *MySQLChecker) Prefix() string {
+	return "MYSQL"
+}
+
+func (c *MySQLChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *MySQLChecker) Fields() []Field {
+	return []Field{
+		{Name: "ADDRESS", Required: true},
+		{Name: "PORT", Default: "3306"},
+		{Name: "USERNAME", Required: true},
+		{Name: "PASSWORD"},
+		{Name: "DATABASE"},
+	}
+}
+
+func (c *MySQLChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	version, err := c.login(ctx, host)
+	if err != nil {
+		res = res.Fail(err)
+	} else {
+		msg := fmt.Sprintf("logged in as %s (server version %s)", host["USERNAME"], version)
+		if host["DATABASE"] != "" {
+			msg = fmt.Sprintf("logged in to database %s as %s (server version %s)", host["DATABASE"], host["USERNAME"], version)
+		}
+		res = res.Pass(msg)
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// login runs the connection phase and returns the server version from the handshake
+func (c *MySQLChecker) login(ctx context.Context, host map[string]string) (string, error) {
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return "", fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, target, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	my := &mysqlConn{conn: conn}
+	payload, err := my.receive()
+	if err != nil {
+		return "", err
+	}
+	hs, err := parseMySQLHandshake(payload)
+	if err != nil {
+		return "", err
+	}
+
+	plugin := hs.plugin
+	if plugin != mysqlNativePassword && plugin != mysqlCachingSHA2 {
+		// the server will send an auth switch request if it really wants something else
+		plugin = mysqlNativePassword
+	}
+	if err := my.send(mysqlHandshakeResponse(host, plugin, hs.scramble)); err != nil {
+		return "", err
+	}
+	if err := my.authResult(host["PASSWORD"], plugin, hs.scramble); err != nil {
+		return "", err
+	}
+	return hs.version, nil
+}
+
+type mysqlHandshake struct {
+	version  string
+	scramble []byte
+	plugin   string
+}
+
+// parseMySQLHandshake reads the parts of the Protocol::HandshakeV10 packet the client needs
+func parseMySQLHandshake(p []byte) (mysqlHandshake, error) {
+	var hs mysqlHandshake
+	if len(p) > 0 && p[0] == 0xff {
+		// the server can refuse before the handshake, like "Host is not allowed to connect"
+		return hs, mysqlError(p)
+	}
+	if len(p) < 1 || p[0] != 10 {
+		return hs, fmt.Errorf("%w: not a MySQL protocol 10 handshake", ErrProtocol)
+	}
+	end := bytes.IndexByte(p[1:], 0)
+	if end < 0 {
+		return hs, fmt.Errorf("%w: short MySQL handshake", ErrProtocol)
+	}
+	hs.version = string(p[1 : 1+end])
+	pos := 1 + end + 1 + 4 // version, connection id
+	if len(p) < pos+8+1+2+1+2+2+1+10 {
+		return hs, fmt.Errorf("%w: short MySQL handshake", ErrProtocol)
+	}
+	hs.scramble = append(hs.scramble, p[pos:pos+8]...)
+	pos += 8 + 1 // scramble part 1, filler
+	capabilities := uint32(binary.LittleEndian.Uint16(p[pos:]))
+	pos += 2 + 1 + 2 // capabilities lower, charset, status
+	capabilities |= uint32(binary.LittleEndian.Uint16(p[pos:])) << 16
+	pos += 2
+	scrambleLen := int(p[pos])
+	pos += 1 + 10 // scramble length, reserved
+
+	if capabilities&mysqlClientSecureConnection != 0 {
+		n := scrambleLen - 8
+		if n < 13 {
+			n = 13
+		}
+		if len(p) < pos+n {
+			return hs, fmt.Errorf("%w: short MySQL handshake", ErrProtocol)
+		}
+		// the second part is NUL terminated
+		hs.scramble = append(hs.scramble, bytes.TrimRight(p[pos:pos+n], "\x00")...)
+		pos += n
+	}
+	if capabilities&mysqlClientPluginAuth != 0 && pos < len(p) {
+		hs.plugin = string(bytes.TrimRight(p[pos:], "\x00"))
+	}
+	return hs, nil
+}
+
+// mysqlHandshakeResponse builds Protocol::HandshakeResponse41
+func mysqlHandshakeResponse(host map[string]string, plugin string, scramble []byte) []byte {
+	flags := mysqlClientLongPassword | mysqlClientProtocol41 | mysqlClientSecureConnection | mysqlClientPluginAuth
+	if host["DATABASE"] != "" {
+		flags |= mysqlClientConnectWithDB
+	}
+	auth := mysqlScramble(plugin, host["PASSWORD"], scramble)
+
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, flags)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(1<<24-1)) // max packet size
+	b.WriteByte(mysqlCharsetUTF8MB4)
+	b.Write(make([]byte, 23))
+	b.WriteString(host["USERNAME"])
+	b.WriteByte(0)
+	b.WriteByte(byte(len(auth)))
+	b.Write(auth)
+	if host["DATABASE"] != "" {
+		b.WriteString(host["DATABASE"])
+		b.WriteByte(0)
+	}
+	b.WriteString(plugin)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// authResult follows the server through auth switches and caching_sha2_password's extra round trips until it sends
+// OK or ERR
+func (my *mysqlConn) authResult(password, plugin string, scramble []byte) error {
+	for {
+		p, err := my.receive()
+		if err != nil {
+			return err
+		}
+		if len(p) == 0 {
+			return fmt.Errorf("%w: empty MySQL packet", ErrProtocol)
+		}
+		switch p[0] {
+		case 0x00:
+			return nil
+		case 0xff:
+			return mysqlError(p)
+		case 0xfe:
+			// auth switch request: plugin name, then new scramble data
+			end := bytes.IndexByte(p[1:], 0)
+			if end < 0 {
+				return fmt.Errorf("%w: bad auth switch request", ErrProtocol)
+			}
+			plugin = string(p[1 : 1+end])
+			scramble = bytes.TrimRight(p[2+end:], "\x00")
+			if plugin != mysqlNativePassword && plugin != mysqlCachingSHA2 {
+				return fmt.Errorf("%w: unsupported MySQL auth plugin %s", ErrProtocol, plugin)
+			}
+			err = my.send(mysqlScramble(plugin, password, scramble))
+		case 0x01:
+			// more auth data. for caching_sha2_password 3 means the fast path worked and OK is next, 4 means the
+			// server wants the password itself. Without TLS that means encrypting it with the server's public key
+			if plugin != mysqlCachingSHA2 || len(p) < 2 {
+				return fmt.Errorf("%w: unexpected auth data for %s", ErrProtocol, plugin)
+			}
+			switch p[1] {
+			case 3:
+				continue
+			case 4:
+				err = my.send([]byte{mysqlRequestKey})
+			default:
+				// the public key we asked for
+				err = my.sendEncryptedPassword(password, scramble, p[1:])
+			}
+		default:
+			return fmt.Errorf("%w: unexpected MySQL packet 0x%02x", ErrProtocol, p[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (my *mysqlConn) sendEncryptedPassword(password string, scramble, keyPEM []byte) error {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return fmt.Errorf("%w: server sent a bad public key", ErrProtocol)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: server sent a bad public key: %v", ErrProtocol, err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: server public key isn't RSA", ErrProtocol)
+	}
+	// an auth switch request can come without data, which leaves nothing to XOR the password with
+	if len(scramble) == 0 {
+		return fmt.Errorf("%w: server sent an empty scramble", ErrProtocol)
+	}
+	plain := append([]byte(password), 0)
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, key, plain, nil)
+	if err != nil {
+		return fmt.Errorf("%w: unable to encrypt password: %v", ErrProtocol, err)
+	}
+	return my.send(enc)
+}
+
+// mysqlScramble hashes the password with the scramble the way the plugin expects. An empty password is sent empty
+func mysqlScramble(plugin, password string, scramble []byte) []byte {
+	if password == "" {
+		return []byte{}
+	}
+	if plugin == mysqlCachingSHA2 {
+		// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+		h1 := sha256.Sum256([]byte(password))
+		h2 := sha256.Sum256(h1[:])
+		h3 := sha256.Sum256(append(h2[:], scramble...))
+		for i := range h1 {
+			h1[i] ^= h3[i]
+		}
+		return h1[:]
+	}
+	// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+	h1 := sha1.Sum([]byte(password))
+	h2 := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte{}, scramble...), h2[:]...))
+	for i := range h1 {
+		h1[i] ^= h3[i]
+	}
+	return h1[:]
+}
+
+// mysqlError turns an ERR packet into an error. 1045 means the user or password was wrong. Anything else, like 1049
+// for an unknown database or 1044 for no access to it, means the server refused the session
+func mysqlError(p []byte) error {
+	if len(p) < 3 {
+		return fmt.Errorf("%w: short MySQL error packet", ErrProtocol)
+	}
+	code := binary.LittleEndian.Uint16(p[1:3])
+	msg := p[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		msg = msg[6:] // skip the SQL state
+	}
+	kind := ErrRejected
+	if code == mysqlErrAccessDenied {
+		kind = ErrAuth
+	}
+	return fmt.Errorf("%w: MySQL error %d: %s", kind, code, msg)
+}
+
+// mysqlConn reads and writes packets: a three byte little endian length, a sequence number, then the payload
+type mysqlConn struct {
+	conn net.Conn
+	seq  byte
+}
+
+func (my *mysqlConn) receive() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(my.conn, header); err != nil {
+		return nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	my.seq = header[3] + 1
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(my.conn, payload); err != nil {
+		return nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	return payload, nil
+}
+
+func (my *mysqlConn) send(payload []byte) error {
+	n := len(payload)
+	packet := append([]byte{byte(n), byte(n >> 8), byte(n >> 16), my.seq}, payload...)
+	my.seq++
+	if _, err := my.conn.Write(packet); err != nil {
+		return fmt.Errorf("%w: writing to server: %v", ErrNetwork, err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package clients
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeMySQL is a local stand-in for a MySQL server with one account
+type fakeMySQL struct {
+	*fakeServer
+	serverPlugin  string // plugin named in the initial handshake
+	accountPlugin string // plugin the account actually uses. a mismatch causes an auth switch
+	cached        bool   // caching_sha2_password fast path works
+	emptySwitch   bool   // send the auth switch request without scramble data
+	key           *rsa.PrivateKey
+	user          string
+	password      string
+	database      string
+}
+
+var fakeMySQLScramble = []byte("abcdefghijklmnopqrst")
+
+func startFakeMySQL(t *testing.T, serverPlugin, accountPlugin string) *fakeMySQL {
+	f := &fakeMySQL{serverPlugin: serverPlugin, accountPlugin: accountPlugin, cached: true,
+		user: "pat", password: "goodpassword", database: "pickles"}
+	f.fakeServer = startFakeServer(t, f.serve)
+	return f
+}
+
+func (f *fakeMySQL) host(user, password, database string) map[string]string {
+	return f.hostMap("MYSQL", "ORDERS", map[string]string{"USERNAME": user, "PASSWORD": password, "DATABASE": database})
+}
+
+func (f *fakeMySQL) serve(conn net.Conn) {
+	my := &mysqlConn{conn: conn}
+
+	var hs bytes.Buffer
+	hs.WriteByte(10)
+	hs.WriteString("8.0.36\x00")
+	hs.Write([]byte{1, 0, 0, 0})
+	hs.Write(fakeMySQLScramble[:8])
+	hs.WriteByte(0)
+	caps := mysqlClientProtocol41 | mysqlClientSecureConnection | mysqlClientPluginAuth | mysqlClientConnectWithDB
+	_ = binary.Write(&hs, binary.LittleEndian, uint16(caps))
+	hs.WriteByte(mysqlCharsetUTF8MB4)
+	hs.Write([]byte{2, 0})
+	_ = binary.Write(&hs, binary.LittleEndian, uint16(caps>>16))
+	hs.WriteByte(21)
+	hs.Write(make([]byte, 10))
+	hs.Write(fakeMySQLScramble[8:])
+	hs.WriteByte(0)
+	hs.WriteString(f.serverPlugin + "\x00")
+	_ = my.send(hs.Bytes())
+
+	p, err := my.receive()
+	if err != nil {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(p)
+	p = p[32:]
+	end := bytes.IndexByte(p, 0)
+	user := string(p[:end])
+	p = p[end+1:]
+	auth := p[1 : 1+int(p[0])]
+	p = p[1+int(p[0]):]
+	database := ""
+	if flags&mysqlClientConnectWithDB != 0 {
+		end = bytes.IndexByte(p, 0)
+		database = string(p[:end])
+		p = p[end+1:]
+	}
+	plugin := strings.TrimRight(string(p), "\x00")
+
+	scramble := fakeMySQLScramble
+	if plugin != f.accountPlugin {
+		switchData := append(append([]byte{}, fakeMySQLScramble...), 0)
+		if f.emptySwitch {
+			scramble, switchData = nil, nil
+		}
+		_ = my.send(append([]byte("\xfe"+f.accountPlugin+"\x00"), switchData...))
+		if auth, err = my.receive(); err != nil {
+			return
+		}
+	}
+
+	ok := user == f.user && bytes.Equal(auth, mysqlScramble(f.accountPlugin, f.password, scramble))
+	if ok && f.accountPlugin == mysqlCachingSHA2 && !f.cached {
+		ok = f.fullAuth(my)
+	} else if ok && f.accountPlugin == mysqlCachingSHA2 {
+		_ = my.send([]byte{0x01, 0x03})
+	}
+	switch {
+	case !ok:
+		_ = my.send(fakeMySQLError(1045, "28000", "Access denied for user '"+user+"'@'localhost' (using password: YES)"))
+	case database != "" && database != f.database:
+		_ = my.send(fakeMySQLError(1049, "42000", "Unknown database '"+database+"'"))
+	default:
+		_ = my.send([]byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00})
+	}
+}
+
+// fullAuth asks for the password, hands out the public key and decrypts what comes back
+func (f *fakeMySQL) fullAuth(my *mysqlConn) bool {
+	_ = my.send([]byte{0x01, 0x04})
+	p, err := my.receive()
+	if err != nil || !bytes.Equal(p, []byte{mysqlRequestKey}) {
+		return false
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	_ = my.send(append([]byte{0x01}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...))
+	enc, err := my.receive()
+	if err != nil {
+		return false
+	}
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, f.key, enc, nil)
+	if err != nil {
+		return false
+	}
+	for i := range plain {
+		plain[i] ^= fakeMySQLScramble[i%len(fakeMySQLScramble)]
+	}
+	return string(plain) == f.password+"\x00"
+}
+
+func fakeMySQLError(code uint16, state, msg string) []byte {
+	p := []byte{0xff, byte(code), byte(code >> 8)}
+	return append(p, []byte("#"+state+msg)...)
+}
+
+func TestMySQLCheckerPlugins(t *testing.T) {
+	cases := []struct{ server, account string }{
+		{mysqlNativePassword, mysqlNativePassword},
+		{mysqlCachingSHA2, mysqlCachingSHA2},
+		// MySQL 8 announces caching_sha2_password and switches for accounts that still use the old plugin
+		{mysqlCachingSHA2, mysqlNativePassword},
+		{mysqlNativePassword, mysqlCachingSHA2},
+	}
+	for _, c := range cases {
+		f := startFakeMySQL(t, c.server, c.account)
+		defer f.Close()
+		res := runChecker(&MySQLChecker{}, f.host("pat", "goodpassword", "pickles"))
+		if !res.OK {
+			t.Errorf("%s/%s: expected login to pass: %s", c.server, c.account, res.Message)
+		}
+		if !strings.Contains(res.Message, "8.0.36") {
+			t.Errorf("%s/%s: server version missing from %q", c.server, c.account, res.Message)
+		}
+
+		res = runChecker(&MySQLChecker{}, f.host("pat", "badpassword", "pickles"))
+		if res.OK || !errors.Is(res.Err, ErrAuth) {
+			t.Errorf("%s/%s: a bad password should be an auth failure: %v", c.server, c.account, res.Err)
+		}
+	}
+}
+
+func TestMySQLCheckerCachingSHA2FullAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := startFakeMySQL(t, mysqlCachingSHA2, mysqlCachingSHA2)
+	defer f.Close()
+	f.cached = false
+	f.key = key
+
+	res := runChecker(&MySQLChecker{}, f.host("pat", "goodpassword", ""))
+	if !res.OK {
+		t.Errorf("expected full auth to pass: %s", res.Message)
+	}
+}
+
+// a server that switches plugins without sending a scramble has nothing to encrypt the password with
+func TestMySQLCheckerEmptyAuthSwitch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := startFakeMySQL(t, mysqlNativePassword, mysqlCachingSHA2)
+	defer f.Close()
+	f.cached = false
+	f.key = key
+	f.emptySwitch = true
+
+	res := runChecker(&MySQLChecker{}, f.host("pat", "goodpassword", ""))
+	if res.OK || !errors.Is(res.Err, ErrProtocol) || !strings.Contains(res.Message, "empty scramble") {
+		t.Errorf("expected an empty scramble to be a protocol error, got %v", res.Err)
+	}
+}
+
+func TestMySQLCheckerUnknownDatabase(t *testing.T) {
+	f := startFakeMySQL(t, mysqlNativePassword, mysqlNativePassword)
+	defer f.Close()
+	res := runChecker(&MySQLChecker{}, f.host("pat", "goodpassword", "sour_pickles"))
+	if res.OK || !errors.Is(res.Err, ErrRejected) {
+		t.Errorf("an unknown database should be rejected, not %v", res.Err)
+	}
+}
+
+func TestMySQLCheckerUnreachable(t *testing.T) {
+	f := startFakeMySQL(t, mysqlNativePassword, mysqlNativePassword)
+	host := f.host("pat", "goodpassword", "")
+	f.Close()
+	res := runChecker(&MySQLChecker{}, host)
+	if res.OK || !errors.Is(res.Err, ErrNetwork) {
+		t.Errorf("a closed port should be a network failure: %v", res.Err)
+	}
+}
+
+// XOR with SHA1(scramble + SHA1(SHA1(password))) should give back SHA1(password)
+func TestMySQLNativeScramble(t *testing.T) {
+	got := mysqlScramble(mysqlNativePassword, "secret", fakeMySQLScramble)
+	h1 := sha1.Sum([]byte("secret"))
+	h2 := sha1.Sum(h1[:])
+	h3 := sha1.Sum(append(append([]byte{}, fakeMySQLScramble...), h2[:]...))
+	for i := range got {
+		if got[i]^h3[i] != h1[i] {
+			t.Fatal("scramble doesn't unwrap to SHA1(password)")
+		}
+	}
+	if len(mysqlScramble(mysqlNativePassword, "", fakeMySQLScramble)) != 0 {
+		t.Error("an empty password should send an empty auth response")
+	}
+}
@@ -0,0 +1,473 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/natemarks/preflight/utility"
+)
+
+// PostgresChecker logs in to a PostgreSQL server with the host's USERNAME and PASSWORD. It speaks just enough of the
+// frontend/backend protocol to get through the startup handshake and authentication (cleartext, md5 and
+// SCRAM-SHA-256), then disconnects without running any queries. DATABASE is optional and defaults to the username,
+// the same as libpq.
+//
+// SSLMODE works like libpq's sslmode and defaults to prefer:
+//
+//	disable       never use TLS
+//	allow         try without TLS, then with TLS if the server refuses the session
+//	prefer        use TLS if the server supports it, without verifying the certificate
+//	require       insist on TLS. With a root certificate it verifies the chain like verify-ca, like libpq does
+//	verify-ca     insist on TLS and verify the chain against SSLROOTCERT, or the system roots when it's unset
+//	verify-full   verify-ca, plus the certificate has to match SERVERNAME or ADDRESS
+//
+// SSLROOTCERT (SSLCERTPATH and CAFILE work too) is the PEM bundle of CAs to trust. SSLCERT and SSLKEY, or CLIENTCERT
+// and CLIENTKEY, are the client certificate for servers that ask for one
+type PostgresChecker struct{}
+
+func init() {
+	Register(&PostgresChecker{})
+}
+
+const (
+	pgProtocolVersion int32 = 196608 // 3.0
+	pgSSLRequestCode  int32 = 80877103
+	pgMaxMessageSize  int32 = 1 << 20
+
+	pgAuthOK                int32 = 0
+	pgAuthCleartextPassword int32 = 3
+	pgAuthMD5Password       int32 = 5
+	pgAuthSASL              int32 = 10
+	pgAuthSASLContinue      int32 = 11
+	pgAuthSASLFinal         int32 = 12
+)
+
+// libpq's sslmode values
+var pgSSLModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func (c *PostgresChecker) Name() string {
+	return "PostgreSQL 10"
+}
+
+func (c *PostgresChecker) Prefix() string {
+	return "POSTGRES10"
+}
+
+func (c *PostgresChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *PostgresChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "ADDRESS", Required: true},
+		{Name: "PORT", Default: "5432"},
+		{Name: "USERNAME", Required: true},
+		{Name: "PASSWORD"},
+		{Name: "DATABASE"},
+		{Name: "SSLMODE", Values: pgSSLModes},
+		{Name: "SSLROOTCERT"},
+		{Name: "SSLCERTPATH"},
+		{Name: "SSLCERT"},
+		{Name: "SSLKEY"},
+	}, TLSFields...)
+}
+
+// StartTLS sends the SSLRequest, so the TLS check can inspect the certificate Postgres switches to
+func (c *PostgresChecker) StartTLS(conn net.Conn) error {
+	secure, err := (&pgConn{conn: conn}).requestSSL()
+	if err != nil {
+		return err
+	}
+	if !secure {
+		return fmt.Errorf("%w: %s doesn't support SSL", ErrTLS, conn.RemoteAddr())
+	}
+	return nil
+}
+
+func (c *PostgresChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	version, secure, err := c.login(ctx, host)
+	if err != nil {
+		res = res.Fail(err)
+	} else {
+		over := "without TLS"
+		if secure {
+			over = "over TLS"
+		}
+		res = res.Pass(fmt.Sprintf("logged in to database %s as %s %s (server version %s)",
+			pgDatabase(host), host["USERNAME"], over, version))
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+// login picks the TLS settings for SSLMODE, runs the startup handshake and returns the server_version the server
+// reported and whether the session used TLS
+func (c *PostgresChecker) login(ctx context.Context, host map[string]string) (string, bool, error) {
+	mode, err := pgSSLMode(host)
+	if err != nil {
+		return "", false, err
+	}
+	if mode == "allow" {
+		// like libpq, only a session the server refused is retried with TLS
+		version, secure, err := c.session(ctx, host, nil, false)
+		if err == nil || !(errors.Is(err, ErrAuth) || errors.Is(err, ErrRejected)) {
+			return version, secure, err
+		}
+	}
+	cfg, err := pgTLSConfig(host, mode)
+	if err != nil {
+		return "", false, err
+	}
+	return c.session(ctx, host, cfg, mode != "prefer")
+}
+
+// session connects, asks for TLS when cfg isn't nil and logs in. required fails the session when the server doesn't
+// support TLS instead of carrying on without it
+func (c *PostgresChecker) session(ctx context.Context, host map[string]string, cfg *tls.Config,
+	required bool) (string, bool, error) {
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, target, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	pg := &pgConn{conn: conn}
+	secure := false
+	if cfg != nil {
+		if secure, err = pg.requestSSL(); err != nil {
+			return "", false, err
+		}
+		if !secure && required {
+			return "", false, fmt.Errorf("%w: %s doesn't support SSL, but SSLMODE requires it", ErrTLS, target)
+		}
+		if secure {
+			tlsConn, err := Handshake(conn, cfg, target)
+			if err != nil {
+				return "", false, err
+			}
+			pg.conn = tlsConn
+		}
+	}
+	if err := pg.startup(host["USERNAME"], pgDatabase(host)); err != nil {
+		return "", false, err
+	}
+	if err := pg.authenticate(host["USERNAME"], host["PASSWORD"]); err != nil {
+		return "", false, err
+	}
+	version, err := pg.waitReady()
+	if err != nil {
+		return "", false, err
+	}
+	// say goodbye so the server doesn't log an unexpected EOF
+	_ = pg.send('X', nil)
+	return version, secure, nil
+}
+
+// pgSSLMode returns SSLMODE, prefer when it's unset
+func pgSSLMode(host map[string]string) (string, error) {
+	mode := strings.ToLower(host["SSLMODE"])
+	if mode == "" {
+		return "prefer", nil
+	}
+	for _, m := range pgSSLModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("SSLMODE must be one of %s, not %q", strings.Join(pgSSLModes, ", "), host["SSLMODE"])
+}
+
+// pgTLSConfig builds the TLS config for an sslmode from the libpq style fields, or the generic ones from tls.go. It's
+// nil for disable
+func pgTLSConfig(host map[string]string, mode string) (*tls.Config, error) {
+	if mode == "disable" {
+		return nil, nil
+	}
+	fields := map[string]string{
+		"ADDRESS":    host["ADDRESS"],
+		"SERVERNAME": host["SERVERNAME"],
+		"CAFILE":     pgFirst(host["SSLROOTCERT"], host["SSLCERTPATH"], host["CAFILE"]),
+		"CLIENTCERT": pgFirst(host["SSLCERT"], host["CLIENTCERT"]),
+		"CLIENTKEY":  pgFirst(host["SSLKEY"], host["CLIENTKEY"]),
+	}
+	cfg, err := TLSConfig(fields)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "require" && fields["CAFILE"] != "" {
+		mode = "verify-ca"
+	}
+	switch mode {
+	case "verify-full":
+	case "verify-ca":
+		// verify the chain but not the name, which crypto/tls can't do on its own
+		roots := cfg.RootCAs
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return pgVerifyChain(raw, roots)
+		}
+	default:
+		cfg.InsecureSkipVerify = true
+	}
+	return cfg, nil
+}
+
+// pgVerifyChain verifies the server's certificate chain against roots, the system roots when it's nil
+func pgVerifyChain(raw [][]byte, roots *x509.CertPool) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("server sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+func pgFirst(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func pgDatabase(host map[string]string) string {
+	if host["DATABASE"] != "" {
+		return host["DATABASE"]
+	}
+	return host["USERNAME"]
+}
+
+// pgConn reads and writes protocol messages: a one byte type, a four byte length that counts itself, then the body
+type pgConn struct {
+	conn net.Conn
+}
+
+// requestSSL sends an SSLRequest and reports whether the server is willing to switch to TLS
+func (pg *pgConn) requestSSL() (bool, error) {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, uint32(pgSSLRequestCode))
+	if err := pg.write(append(pgLength(len(body)), body...)); err != nil {
+		return false, err
+	}
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(pg.conn, answer); err != nil {
+		return false, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	switch answer[0] {
+	case 'S':
+		return true, nil
+	case 'N':
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: unexpected answer %q to SSLRequest", ErrProtocol, answer[0])
+}
+
+func (pg *pgConn) startup(user, database string) error {
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, pgProtocolVersion)
+	for _, kv := range [][2]string{{"user", user}, {"database", database}, {"application_name", "preflight"}} {
+		body.WriteString(kv[0])
+		body.WriteByte(0)
+		body.WriteString(kv[1])
+		body.WriteByte(0)
+	}
+	body.WriteByte(0)
+	// the startup message is the only one without a type byte
+	return pg.write(append(pgLength(body.Len()), body.Bytes()...))
+}
+
+func (pg *pgConn) authenticate(user, password string) error {
+	for {
+		typ, body, err := pg.receive()
+		if err != nil {
+			return err
+		}
+		if typ != 'R' || len(body) < 4 {
+			return fmt.Errorf("%w: expected an authentication request, got message %q", ErrProtocol, typ)
+		}
+		code := int32(binary.BigEndian.Uint32(body))
+		switch code {
+		case pgAuthOK:
+			return nil
+		case pgAuthCleartextPassword:
+			err = pg.send('p', pgString(password))
+		case pgAuthMD5Password:
+			if len(body) < 8 {
+				return fmt.Errorf("%w: md5 request without a salt", ErrProtocol)
+			}
+			err = pg.send('p', pgString(pgMD5Password(user, password, body[4:8])))
+		case pgAuthSASL:
+			err = pg.scram(password, body[4:])
+		default:
+			return fmt.Errorf("%w: unsupported authentication method %d", ErrProtocol, code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scram runs SCRAM-SHA-256 from the SASL request through to the SASLFinal message. AuthenticationOk follows it
+func (pg *pgConn) scram(password string, mechanisms []byte) error {
+	if !utility.Contains(strings.Split(string(mechanisms), "\x00"), "SCRAM-SHA-256") {
+		return fmt.Errorf("%w: server offered no supported SASL mechanism: %q", ErrProtocol, mechanisms)
+	}
+	// Postgres takes the user name from the startup message and ignores the one in the SCRAM message
+	client := newScramClient(sha256.New, "", password)
+	first := client.ClientFirst()
+	var body bytes.Buffer
+	body.Write(pgString("SCRAM-SHA-256"))
+	_ = binary.Write(&body, binary.BigEndian, int32(len(first)))
+	body.WriteString(first)
+	if err := pg.send('p', body.Bytes()); err != nil {
+		return err
+	}
+
+	serverFirst, err := pg.expectAuth(pgAuthSASLContinue)
+	if err != nil {
+		return err
+	}
+	final, err := client.ClientFinal(string(serverFirst))
+	if err != nil {
+		return err
+	}
+	if err := pg.send('p', []byte(final)); err != nil {
+		return err
+	}
+	serverFinal, err := pg.expectAuth(pgAuthSASLFinal)
+	if err != nil {
+		return err
+	}
+	return client.VerifyServerFinal(string(serverFinal))
+}
+
+// expectAuth reads an authentication message with the given code and returns its data
+func (pg *pgConn) expectAuth(code int32) ([]byte, error) {
+	typ, body, err := pg.receive()
+	if err != nil {
+		return nil, err
+	}
+	if typ != 'R' || len(body) < 4 || int32(binary.BigEndian.Uint32(body)) != code {
+		return nil, fmt.Errorf("%w: expected authentication message %d", ErrProtocol, code)
+	}
+	return body[4:], nil
+}
+
+// waitReady reads past the parameter status and key data messages until the server is ready for a query
+func (pg *pgConn) waitReady() (string, error) {
+	version := "unknown"
+	for {
+		typ, body, err := pg.receive()
+		if err != nil {
+			return "", err
+		}
+		switch typ {
+		case 'Z':
+			return version, nil
+		case 'S':
+			parts := bytes.Split(body, []byte{0})
+			if len(parts) >= 2 && string(parts[0]) == "server_version" {
+				version = string(parts[1])
+			}
+		}
+	}
+}
+
+// receive returns the next message that isn't a notice. Error responses are turned into errors
+func (pg *pgConn) receive() (byte, []byte, error) {
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(pg.conn, header); err != nil {
+			return 0, nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+		}
+		length := int32(binary.BigEndian.Uint32(header[1:]))
+		if length < 4 || length > pgMaxMessageSize {
+			return 0, nil, fmt.Errorf("%w: bad message length %d", ErrProtocol, length)
+		}
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(pg.conn, body); err != nil {
+			return 0, nil, fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+		}
+		switch header[0] {
+		case 'N':
+			continue
+		case 'E':
+			return 0, nil, pgError(body)
+		}
+		return header[0], body, nil
+	}
+}
+
+func (pg *pgConn) send(typ byte, body []byte) error {
+	msg := append([]byte{typ}, pgLength(len(body))...)
+	return pg.write(append(msg, body...))
+}
+
+func (pg *pgConn) write(b []byte) error {
+	if _, err := pg.conn.Write(b); err != nil {
+		return fmt.Errorf("%w: writing to server: %v", ErrNetwork, err)
+	}
+	return nil
+}
+
+// pgError turns an ErrorResponse into an error. SQLSTATE class 28 is "invalid authorization specification", so those
+// are auth failures. Anything else, like 3D000 for a missing database, means the server refused the session
+func pgError(body []byte) error {
+	fields := make(map[byte]string)
+	for _, f := range bytes.Split(body, []byte{0}) {
+		if len(f) > 1 {
+			fields[f[0]] = string(f[1:])
+		}
+	}
+	kind := ErrRejected
+	if strings.HasPrefix(fields['C'], "28") {
+		kind = ErrAuth
+	}
+	return fmt.Errorf("%w: %s %s: %s", kind, fields['S'], fields['C'], fields['M'])
+}
+
+// the md5 response is "md5" + md5(md5(password + user) + salt), all in hex
+func pgMD5Password(user, password string, salt []byte) string {
+	inner := fmt.Sprintf("%x", md5.Sum([]byte(password+user)))
+	return fmt.Sprintf("md5%x", md5.Sum(append([]byte(inner), salt...)))
+}
+
+func pgString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func pgLength(bodyLen int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(bodyLen+4))
+	return b
+}
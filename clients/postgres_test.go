@@ -0,0 +1,318 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePostgres is a local stand-in for a Postgres server. It only knows one user and one database. It answers
+// SSLRequests with tlsConfig, or turns them down when that's nil. hostSSL refuses sessions without TLS, like a
+// pg_hba.conf with only hostssl lines
+type fakePostgres struct {
+	*fakeServer
+	auth      string // trust, cleartext, md5 or scram
+	user      string
+	password  string
+	database  string
+	tlsConfig *tls.Config
+	hostSSL   bool
+}
+
+func startFakePostgres(t *testing.T, auth string) *fakePostgres {
+	f := &fakePostgres{auth: auth, user: "pat", password: "goodpassword", database: "pickles"}
+	f.fakeServer = startFakeServer(t, f.serve)
+	return f
+}
+
+// host returns a host map pointing at the fake server
+func (f *fakePostgres) host(user, password, database string) map[string]string {
+	return f.hostMap("POSTGRES10", "HOT_PICKLES", map[string]string{"USERNAME": user, "PASSWORD": password,
+		"DATABASE": database})
+}
+
+func (f *fakePostgres) serve(conn net.Conn) {
+	params, err := fakePGStartup(conn)
+	if err != nil {
+		return
+	}
+	secure := false
+	if params["ssl"] != "" {
+		if f.tlsConfig == nil {
+			_, _ = conn.Write([]byte{'N'})
+		} else {
+			_, _ = conn.Write([]byte{'S'})
+			tlsConn := tls.Server(conn, f.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, secure = tlsConn, true
+		}
+		if params, err = fakePGStartup(conn); err != nil {
+			return
+		}
+	}
+	if f.hostSSL && !secure {
+		fakePGSend(conn, 'E', fakePGError("28000", "no pg_hba.conf entry for host \"127.0.0.1\", no encryption"))
+		return
+	}
+	if !f.authenticate(conn, params["user"]) {
+		fakePGSend(conn, 'E', fakePGError("28P01", fmt.Sprintf("password authentication failed for user \"%s\"", params["user"])))
+		return
+	}
+	if params["database"] != f.database {
+		fakePGSend(conn, 'E', fakePGError("3D000", fmt.Sprintf("database \"%s\" does not exist", params["database"])))
+		return
+	}
+	fakePGSend(conn, 'R', fakePGInt(pgAuthOK))
+	fakePGSend(conn, 'S', []byte("server_version\x0010.23\x00"))
+	fakePGSend(conn, 'K', make([]byte, 8))
+	fakePGSend(conn, 'Z', []byte{'I'})
+	_, _, _ = fakePGReceive(conn)
+}
+
+func (f *fakePostgres) authenticate(conn net.Conn, user string) bool {
+	switch f.auth {
+	case "trust":
+		return user == f.user
+	case "cleartext":
+		fakePGSend(conn, 'R', fakePGInt(pgAuthCleartextPassword))
+		_, body, err := fakePGReceive(conn)
+		return err == nil && user == f.user && string(body) == f.password+"\x00"
+	case "md5":
+		salt := []byte{1, 2, 3, 4}
+		fakePGSend(conn, 'R', append(fakePGInt(pgAuthMD5Password), salt...))
+		_, body, err := fakePGReceive(conn)
+		return err == nil && user == f.user && string(body) == pgMD5Password(f.user, f.password, salt)+"\x00"
+	case "scram":
+		fakePGSend(conn, 'R', append(fakePGInt(pgAuthSASL), []byte("SCRAM-SHA-256\x00\x00")...))
+		_, body, err := fakePGReceive(conn)
+		if err != nil {
+			return false
+		}
+		// mechanism name, then a length prefixed client-first-message
+		parts := bytes.SplitN(body, []byte{0}, 2)
+		server := newScramServer(sha256.New, f.password)
+		fakePGSend(conn, 'R', append(fakePGInt(pgAuthSASLContinue), server.First(string(parts[1][4:]))...))
+		_, body, err = fakePGReceive(conn)
+		if err != nil {
+			return false
+		}
+		final, ok := server.Final(string(body))
+		if !ok || user != f.user {
+			return false
+		}
+		fakePGSend(conn, 'R', append(fakePGInt(pgAuthSASLFinal), final...))
+		return true
+	}
+	return false
+}
+
+func fakePGStartup(conn net.Conn) (map[string]string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header)-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	if int32(binary.BigEndian.Uint32(body)) == pgSSLRequestCode {
+		res["ssl"] = "requested"
+		return res, nil
+	}
+	parts := bytes.Split(body[4:], []byte{0})
+	for i := 0; i+1 < len(parts); i += 2 {
+		res[string(parts[i])] = string(parts[i+1])
+	}
+	return res, nil
+}
+
+func fakePGReceive(conn net.Conn) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	_, err := io.ReadFull(conn, body)
+	return header[0], body, err
+}
+
+func fakePGSend(conn net.Conn, typ byte, body []byte) {
+	_, _ = conn.Write(append(append([]byte{typ}, pgLength(len(body))...), body...))
+}
+
+func fakePGInt(i int32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(i))
+	return b
+}
+
+func fakePGError(code, msg string) []byte {
+	return []byte(fmt.Sprintf("SFATAL\x00C%s\x00M%s\x00\x00", code, msg))
+}
+
+func TestPostgresCheckerAuthMethods(t *testing.T) {
+	for _, auth := range []string{"trust", "cleartext", "md5", "scram"} {
+		f := startFakePostgres(t, auth)
+		defer f.Close()
+		res := runChecker(&PostgresChecker{}, f.host("pat", "goodpassword", "pickles"))
+		if !res.OK {
+			t.Errorf("%s: expected login to pass: %s", auth, res.Message)
+		}
+		if res.ID != "HOT_PICKLES" {
+			t.Errorf("%s: result ID = %s", auth, res.ID)
+		}
+
+		if auth == "trust" {
+			continue
+		}
+		res = runChecker(&PostgresChecker{}, f.host("pat", "badpassword", "pickles"))
+		if res.OK {
+			t.Errorf("%s: expected a bad password to fail", auth)
+		}
+		if !errors.Is(res.Err, ErrAuth) {
+			t.Errorf("%s: a bad password should be an auth failure: %v", auth, res.Err)
+		}
+	}
+}
+
+func TestPostgresCheckerMissingDatabase(t *testing.T) {
+	f := startFakePostgres(t, "md5")
+	defer f.Close()
+	res := runChecker(&PostgresChecker{}, f.host("pat", "goodpassword", "sour_pickles"))
+	if res.OK || !errors.Is(res.Err, ErrRejected) {
+		t.Errorf("a missing database should be rejected: %v", res.Err)
+	}
+}
+
+// DATABASE is optional and defaults to the user name like libpq
+func TestPostgresCheckerDefaultDatabase(t *testing.T) {
+	f := startFakePostgres(t, "md5")
+	defer f.Close()
+	f.database = "pat"
+	res := runChecker(&PostgresChecker{}, f.host("pat", "goodpassword", ""))
+	if !res.OK {
+		t.Errorf("expected login to the default database to pass: %s", res.Message)
+	}
+}
+
+func TestPostgresCheckerUnreachable(t *testing.T) {
+	f := startFakePostgres(t, "md5")
+	defer f.Close()
+	host := f.host("pat", "goodpassword", "pickles")
+	_ = f.ln.Close()
+	res := runChecker(&PostgresChecker{}, host)
+	if res.OK || !errors.Is(res.Err, ErrNetwork) {
+		t.Errorf("a closed port should be a network failure: %v", res.Err)
+	}
+}
+
+// startFakePostgresTLS is a scram server with a certificate for localhost and 127.0.0.1 from ca
+func startFakePostgresTLS(t *testing.T, ca *testCA, hostSSL bool) *fakePostgres {
+	f := startFakePostgres(t, "scram")
+	f.tlsConfig, f.hostSSL = ca.serverConfig(t), hostSSL
+	return f
+}
+
+func TestPostgresCheckerSSLMode(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	other := newTestCA(t)
+	defer other.Close()
+	tlsServer := startFakePostgresTLS(t, ca, false)
+	defer tlsServer.Close()
+	hostSSL := startFakePostgresTLS(t, ca, true)
+	defer hostSSL.Close()
+	plain := startFakePostgres(t, "scram")
+	defer plain.Close()
+
+	cases := []struct {
+		name   string
+		server *fakePostgres
+		fields map[string]string
+		kind   error
+		over   string
+	}{
+		{"default prefers TLS", tlsServer, nil, nil, "over TLS"},
+		{"prefer without server support", plain, map[string]string{"SSLMODE": "prefer"}, nil, "without TLS"},
+		{"disable", tlsServer, map[string]string{"SSLMODE": "disable"}, nil, "without TLS"},
+		{"disable with hostssl", hostSSL, map[string]string{"SSLMODE": "disable"}, ErrAuth, ""},
+		{"allow", tlsServer, map[string]string{"SSLMODE": "allow"}, nil, "without TLS"},
+		{"allow retries with TLS", hostSSL, map[string]string{"SSLMODE": "allow"}, nil, "over TLS"},
+		{"require", tlsServer, map[string]string{"SSLMODE": "require"}, nil, "over TLS"},
+		{"require without server support", plain, map[string]string{"SSLMODE": "require"}, ErrTLS, ""},
+		{"require with a root cert verifies", tlsServer, map[string]string{"SSLMODE": "require", "SSLROOTCERT": other.caFile}, ErrTLS, ""},
+		{"verify-ca", tlsServer, map[string]string{"SSLMODE": "verify-ca", "SSLCERTPATH": ca.caFile, "SERVERNAME": "db.domain.com"}, nil, "over TLS"},
+		{"verify-ca untrusted", tlsServer, map[string]string{"SSLMODE": "verify-ca", "SSLROOTCERT": other.caFile}, ErrTLS, ""},
+		{"verify-full", tlsServer, map[string]string{"SSLMODE": "verify-full", "SSLROOTCERT": ca.caFile}, nil, "over TLS"},
+		{"verify-full wrong name", tlsServer, map[string]string{"SSLMODE": "VERIFY-FULL", "SSLROOTCERT": ca.caFile, "SERVERNAME": "db.domain.com"}, ErrTLS, ""},
+	}
+	for _, c := range cases {
+		host := c.server.host("pat", "goodpassword", "pickles")
+		for k, v := range c.fields {
+			host[k] = v
+		}
+		res := runChecker(&PostgresChecker{}, host)
+		if c.kind == nil && (!res.OK || !strings.Contains(res.Message, c.over)) {
+			t.Errorf("%s: expected login %s to pass: %s", c.name, c.over, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.kind, res.Err)
+		}
+	}
+
+	res := runChecker(&PostgresChecker{}, map[string]string{"ID": "HOT_PICKLES", "SSLMODE": "ca-verify"})
+	if res.OK || res.Err == nil || !strings.Contains(res.Err.Error(), "SSLMODE must be one of") {
+		t.Errorf("expected an unknown SSLMODE to be a config error, got %v", res.Err)
+	}
+}
+
+// the TLS check asks Postgres to switch to TLS before the handshake, like libpq does
+func TestInspectTLSStartTLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	tlsServer := startFakePostgresTLS(t, ca, false)
+	defer tlsServer.Close()
+	plain := startFakePostgres(t, "scram")
+	defer plain.Close()
+
+	for _, f := range []*fakePostgres{tlsServer, plain} {
+		host := f.host("pat", "goodpassword", "pickles")
+		host["TLS"], host["CAFILE"] = "true", ca.caFile
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		cert, err := InspectTLS(ctx, host, Endpoint{Address: host["ADDRESS"], Port: host["PORT"]})
+		cancel()
+		if f == tlsServer && (err != nil || cert.Subject.CommonName != "localhost") {
+			t.Errorf("expected the certificate after the SSLRequest, got %v, %v", cert, err)
+		}
+		if f == plain && !errors.Is(err, ErrTLS) {
+			t.Errorf("expected a server without SSL to be a TLS failure, got %v", err)
+		}
+	}
+}
+
+func TestPgVerifyChain(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cert := ca.issue(t, time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	if err := pgVerifyChain(cert.Certificate, roots); err != nil {
+		t.Errorf("expected the chain to verify: %v", err)
+	}
+	if err := pgVerifyChain(cert.Certificate, x509.NewCertPool()); err == nil {
+		t.Error("expected an untrusted chain to fail")
+	}
+}
@@ -0,0 +1,184 @@
+package clients
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisChecker speaks just enough RESP to prove the host's credentials work: AUTH (with USERNAME for Redis 6 ACL
+// users), SELECT for a DB index other than 0, then PING. A bare tcp connect succeeds even when the password was
+// rotated, which is the failure this catches. TLS=true connects with TLS, see tls.go
+type RedisChecker struct{}
+
+func init() {
+	Register(&RedisChecker{})
+}
+
+// maximum size of a reply line or bulk string. PING and friends only return a few bytes
+const redisMaxReply = 64 * 1024
+
+func (c *RedisChecker) Name() string {
+	return "Redis"
+}
+
+func (c *RedisChecker) Prefix() string {
+	return "REDIS"
+}
+
+func (c *RedisChecker) RequiredFields() []string {
+	return required(c.Fields())
+}
+
+func (c *RedisChecker) Fields() []Field {
+	return append([]Field{
+		{Name: "ADDRESS", Required: true},
+		{Name: "PORT", Default: "6379"},
+		{Name: "USERNAME"},
+		{Name: "PASSWORD"},
+		{Name: "DB"},
+	}, TLSFields...)
+}
+
+func (c *RedisChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	if err := c.ping(ctx, host); err != nil {
+		res = res.Fail(err)
+	} else {
+		res = res.Pass(fmt.Sprintf("PING succeeded on database %s", redisDB(host)))
+	}
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (c *RedisChecker) ping(ctx context.Context, host map[string]string) error {
+	db := redisDB(host)
+	if _, err := strconv.Atoi(db); err != nil {
+		return fmt.Errorf("DB must be a number, not %q", db)
+	}
+	useTLS, err := TLSEnabled(host)
+	if err != nil {
+		return err
+	}
+
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, target, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if useTLS {
+		cfg, err := TLSConfig(host)
+		if err != nil {
+			return err
+		}
+		tlsConn, err := Handshake(conn, cfg, target)
+		if err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	r := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+	if host["PASSWORD"] != "" {
+		args := []string{"AUTH", host["PASSWORD"]}
+		if host["USERNAME"] != "" {
+			args = []string{"AUTH", host["USERNAME"], host["PASSWORD"]}
+		}
+		if _, err := r.do(args...); err != nil {
+			return err
+		}
+	}
+	if db != "0" {
+		if _, err := r.do("SELECT", db); err != nil {
+			return err
+		}
+	}
+	reply, err := r.do("PING")
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("%w: PING answered %q", ErrProtocol, reply)
+	}
+	_, _ = r.do("QUIT")
+	return nil
+}
+
+func redisDB(host map[string]string) string {
+	if host["DB"] == "" {
+		return "0"
+	}
+	return host["DB"]
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command as an array of bulk strings and returns the reply. Error replies become errors
+func (r *redisConn) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		return "", fmt.Errorf("%w: writing to server: %v", ErrNetwork, err)
+	}
+	return r.reply(args[0])
+}
+
+// reply reads a simple string, error, integer or bulk string reply
+func (r *redisConn) reply(command string) (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", fmt.Errorf("%w: empty reply to %s", ErrProtocol, command)
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", redisError(command, line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n > redisMaxReply {
+			return "", fmt.Errorf("%w: bad bulk length in reply to %s", ErrProtocol, command)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return "", fmt.Errorf("%w: reading from server: %v", ErrNetwork, err)
+		}
+		return string(buf[:n]), nil
+	}
+	return "", fmt.Errorf("%w: unexpected reply to %s: %q", ErrProtocol, command, line)
+}
+
+// redisError sorts an error reply. WRONGPASS, NOAUTH and a failed AUTH are credential problems. Anything else, like a
+// DB index out of range or NOPERM for the ACL user, means the server refused the command
+func redisError(command, msg string) error {
+	code := strings.SplitN(msg, " ", 2)[0]
+	switch {
+	case code == "WRONGPASS", code == "NOAUTH", command == "AUTH":
+		return fmt.Errorf("%w: %s: %s", ErrAuth, command, msg)
+	}
+	return fmt.Errorf("%w: %s: %s", ErrRejected, command, msg)
+}
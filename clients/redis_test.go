@@ -0,0 +1,138 @@
+package clients
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeRedis is a local stand-in for a Redis server with a default user password and one ACL user
+type fakeRedis struct {
+	*fakeServer
+	password  string
+	aclUser   string
+	aclPass   string
+	databases int
+}
+
+func startFakeRedis(t *testing.T, tlsConfig *tls.Config) *fakeRedis {
+	f := &fakeRedis{password: "goodpassword", aclUser: "cache", aclPass: "aclpassword", databases: 16}
+	f.fakeServer = startFakeTLSServer(t, tlsConfig, f.serve)
+	return f
+}
+
+func (f *fakeRedis) host(fields map[string]string) map[string]string {
+	return f.hostMap("REDIS", "CACHE", fields)
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		args, err := fakeRedisCommand(r)
+		if err != nil {
+			return
+		}
+		reply := "+OK"
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			user, pass := "default", args[len(args)-1]
+			if len(args) == 3 {
+				user = args[1]
+			}
+			authed = (user == "default" && pass == f.password) || (user == f.aclUser && pass == f.aclPass)
+			if !authed {
+				reply = "-WRONGPASS invalid username-password pair or user is disabled."
+			}
+		case "SELECT":
+			if n, _ := strconv.Atoi(args[1]); n >= f.databases {
+				reply = "-ERR DB index is out of range"
+			}
+		case "PING":
+			reply = "+PONG"
+		case "QUIT":
+			_, _ = io.WriteString(conn, "+OK\r\n")
+			return
+		}
+		if !authed && strings.ToUpper(args[0]) != "AUTH" {
+			reply = "-NOAUTH Authentication required."
+		}
+		_, _ = io.WriteString(conn, reply+"\r\n")
+	}
+}
+
+func fakeRedisCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func TestRedisChecker(t *testing.T) {
+	f := startFakeRedis(t, nil)
+	defer f.Close()
+
+	cases := []struct {
+		name   string
+		fields map[string]string
+		kind   error
+	}{
+		{"default user", map[string]string{"PASSWORD": "goodpassword"}, nil},
+		{"acl user", map[string]string{"USERNAME": "cache", "PASSWORD": "aclpassword", "DB": "3"}, nil},
+		{"rotated password", map[string]string{"PASSWORD": "oldpassword"}, ErrAuth},
+		{"no password", map[string]string{}, ErrAuth},
+		{"bad db index", map[string]string{"PASSWORD": "goodpassword", "DB": "99"}, ErrRejected},
+	}
+	for _, c := range cases {
+		res := runChecker(&RedisChecker{}, f.host(c.fields))
+		if c.kind == nil && !res.OK {
+			t.Errorf("%s: expected PING to pass: %s", c.name, res.Message)
+		}
+		if c.kind != nil && (res.OK || !errors.Is(res.Err, c.kind)) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.kind, res.Err)
+		}
+	}
+}
+
+func TestRedisCheckerBadDB(t *testing.T) {
+	res := runChecker(&RedisChecker{}, map[string]string{"ID": "CACHE", "CLIENT": "REDIS", "ADDRESS": "127.0.0.1", "PORT": "1", "DB": "one"})
+	if res.OK || errors.Is(res.Err, ErrNetwork) {
+		t.Errorf("a non-numeric DB is a config error, not %v", res.Err)
+	}
+}
+
+func TestRedisCheckerTLS(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	f := startFakeRedis(t, ca.serverConfig(t))
+	defer f.Close()
+
+	res := runChecker(&RedisChecker{}, f.host(map[string]string{"PASSWORD": "goodpassword", "TLS": "true", "CAFILE": ca.caFile, "ADDRESS": "localhost"}))
+	if !res.OK {
+		t.Errorf("expected PING over TLS to pass: %s", res.Message)
+	}
+	// without the CA the server's certificate can't be verified
+	res = runChecker(&RedisChecker{}, f.host(map[string]string{"PASSWORD": "goodpassword", "TLS": "true", "ADDRESS": "localhost"}))
+	if res.OK || !errors.Is(res.Err, ErrTLS) {
+		t.Errorf("expected the TLS handshake to fail: %v", res.Err)
+	}
+}
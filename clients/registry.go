@@ -0,0 +1,50 @@
+package clients
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The registry maps environment variable prefixes to the checkers that handle them. config.GetHostFromEV consults it
+// to decide which environment variables hold host data, and the client access checks use it to find the right
+// Checker for each host map.
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Checker)
+)
+
+// Register makes a checker available by its prefix. Like database/sql.Register, it panics if the checker is nil or
+// the prefix is already taken, since that's a programming error rather than a config error
+func Register(c Checker) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if c == nil {
+		panic("clients: Register checker is nil")
+	}
+	prefix := c.Prefix()
+	if _, dup := registry[prefix]; dup {
+		panic(fmt.Sprintf("clients: Register called twice for prefix %s", prefix))
+	}
+	registry[prefix] = c
+}
+
+// Lookup returns the checker registered for the prefix
+func Lookup(prefix string) (Checker, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[prefix]
+	return c, ok
+}
+
+// Prefixes returns a sorted list of all registered prefixes
+func Prefixes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	res := make([]string, 0, len(registry))
+	for prefix := range registry {
+		res = append(res, prefix)
+	}
+	sort.Strings(res)
+	return res
+}
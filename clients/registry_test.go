@@ -0,0 +1,78 @@
+package clients
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeChecker struct {
+	prefix string
+}
+
+func (c *fakeChecker) Name() string             { return "fake" }
+func (c *fakeChecker) Prefix() string           { return c.prefix }
+func (c *fakeChecker) RequiredFields() []string { return []string{"ADDRESS"} }
+func (c *fakeChecker) Run(ctx context.Context, host map[string]string) Result {
+	return NewResult(host).Pass("fake")
+}
+
+// unregister removes a checker a test registered, so the tests can run more than once in a process
+func unregister(prefix string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, prefix)
+}
+
+func TestRegisterLookup(t *testing.T) {
+	Register(&fakeChecker{prefix: "FAKEREGISTRY"})
+	defer unregister("FAKEREGISTRY")
+	c, ok := Lookup("FAKEREGISTRY")
+	if !ok {
+		t.Fatal("registered checker not found")
+	}
+	if c.Prefix() != "FAKEREGISTRY" {
+		t.Errorf("Lookup(FAKEREGISTRY).Prefix() = %s", c.Prefix())
+	}
+	if _, ok := Lookup("NOT_REGISTERED"); ok {
+		t.Error("Lookup found a checker that was never registered")
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	Register(&fakeChecker{prefix: "FAKEDUP"})
+	defer unregister("FAKEDUP")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a duplicate prefix should panic")
+		}
+	}()
+	Register(&fakeChecker{prefix: "FAKEDUP"})
+}
+
+func TestPrefixesIncludesBuiltins(t *testing.T) {
+	prefixes := Prefixes()
+	found := false
+	for i, p := range prefixes {
+		if p == "POSTGRES10" {
+			found = true
+		}
+		if i > 0 && prefixes[i-1] > p {
+			t.Errorf("Prefixes() is not sorted: %v", prefixes)
+		}
+	}
+	if !found {
+		t.Errorf("Prefixes() = %v; want POSTGRES10 registered", prefixes)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	c := &fakeChecker{prefix: "FAKEMISSING"}
+	got := MissingFields(c, map[string]string{"ID": "X", "ADDRESS": ""})
+	if len(got) != 1 || got[0] != "ADDRESS" {
+		t.Errorf("MissingFields() = %v; want [ADDRESS]", got)
+	}
+	got = MissingFields(c, map[string]string{"ID": "X", "ADDRESS": "127.0.0.1"})
+	if len(got) != 0 {
+		t.Errorf("MissingFields() = %v; want []", got)
+	}
+}
@@ -0,0 +1,67 @@
+package clients
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field is a host map field a checker reads
+type Field struct {
+	Name     string
+	Required bool
+	// Default is used when the field isn't set. Required fields don't have one
+	Default string
+	// Values are the ones the field can have, ignoring case. Empty means anything goes
+	Values []string
+}
+
+// Check returns an error if the value isn't one of the field's Values
+func (f Field) Check(val string) error {
+	if len(f.Values) == 0 {
+		return nil
+	}
+	for _, v := range f.Values {
+		if strings.EqualFold(val, v) {
+			return nil
+		}
+	}
+	return fmt.Errorf("has to be one of %s, not %q", strings.Join(f.Values, ", "), val)
+}
+
+// Schema is implemented by checkers that declare every field they read. Defaults fill in missing fields before the
+// host is checked, and any field that isn't in the schema or CommonFields gets a warning because it's probably a typo.
+// The schema is the only list of fields: RequiredFields returns required(c.Fields())
+type Schema interface {
+	Fields() []Field
+}
+
+// CommonFields are the attributes from the README any host can have on top of its client's fields
+var CommonFields = []string{"DESCRIPTION", "VERSION", "TIMEOUT"}
+
+// TLSFields are the fields from tls.go, which the TLS check reads. Checkers whose hosts can have TLS=true add them to
+// their schema. A schema without them, like MySQL's, means the TLS check can't inspect the client's hosts
+var TLSFields = []Field{{Name: "TLS"}, {Name: "SERVERNAME"}, {Name: "CAFILE"}, {Name: "CLIENTCERT"}, {Name: "CLIENTKEY"}}
+
+// required returns the names of the required fields, so checkers with a Schema can derive RequiredFields from it
+func required(fields []Field) []string {
+	var res []string
+	for _, f := range fields {
+		if f.Required {
+			res = append(res, f.Name)
+		}
+	}
+	return res
+}
+
+// Fields returns the checker's fields and whether that's all of them. Checkers that don't implement Schema only
+// have their RequiredFields, so there's no telling which other fields are unknown
+func Fields(c Checker) ([]Field, bool) {
+	if s, ok := c.(Schema); ok {
+		return s.Fields(), true
+	}
+	var res []Field
+	for _, name := range c.RequiredFields() {
+		res = append(res, Field{Name: name, Required: true})
+	}
+	return res, false
+}
@@ -0,0 +1,41 @@
+package clients
+
+import (
+	"strings"
+	"testing"
+)
+
+// RequiredFields comes from the schema, which can't declare a field twice or give a required one a default
+func TestSchemasMatchRequiredFields(t *testing.T) {
+	common := make(map[string]bool)
+	for _, name := range CommonFields {
+		common[name] = true
+	}
+	for _, prefix := range Prefixes() {
+		c, _ := Lookup(prefix)
+		fields, complete := Fields(c)
+		if !complete {
+			continue
+		}
+		byName := make(map[string]Field)
+		for _, f := range fields {
+			if _, dup := byName[f.Name]; dup || common[f.Name] {
+				t.Errorf("%s: %s is declared twice or is a common field", prefix, f.Name)
+			}
+			if f.Required && f.Default != "" {
+				t.Errorf("%s: required field %s has a default", prefix, f.Name)
+			}
+			byName[f.Name] = f
+		}
+		if got, want := strings.Join(c.RequiredFields(), ","), strings.Join(required(fields), ","); got != want {
+			t.Errorf("%s: RequiredFields() = %s, but the schema requires %s", prefix, got, want)
+		}
+	}
+}
+
+func TestFieldsWithoutSchema(t *testing.T) {
+	fields, complete := Fields(&fakeChecker{prefix: "FAKESCHEMA"})
+	if complete || len(fields) != 1 || fields[0].Name != "ADDRESS" || !fields[0].Required {
+		t.Errorf("expected just the required ADDRESS field, got %v, %v", fields, complete)
+	}
+}
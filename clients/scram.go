@@ -0,0 +1,135 @@
+package clients
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// scramClient runs the client side of a SCRAM exchange (RFC 5802, RFC 7677). The transport differs between servers
+// (Postgres SASL messages, MongoDB saslStart/saslContinue, ...) but the three messages are always the same:
+//
+//	client-first -> server-first -> client-final -> server-final
+//
+// Passwords are used as given. SASLprep normalization is skipped, which only matters for non-ASCII passwords
+type scramClient struct {
+	hash            func() hash.Hash
+	username        string
+	password        string
+	clientNonce     string
+	clientFirstBare string
+	saltedPassword  []byte
+	authMessage     string
+}
+
+func newScramClient(h func() hash.Hash, username, password string) *scramClient {
+	nonce := make([]byte, 18)
+	_, _ = rand.Read(nonce)
+	return &scramClient{
+		hash:        h,
+		username:    username,
+		password:    password,
+		clientNonce: base64.StdEncoding.EncodeToString(nonce),
+	}
+}
+
+// ClientFirst returns the client-first-message. The gs2 header "n,," means no channel binding
+func (c *scramClient) ClientFirst() string {
+	name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.username)
+	c.clientFirstBare = fmt.Sprintf("n=%s,r=%s", name, c.clientNonce)
+	return "n,," + c.clientFirstBare
+}
+
+// ClientFinal parses the server-first-message and returns the client-final-message with the proof
+func (c *scramClient) ClientFinal(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt64, iter := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) == len(c.clientNonce) {
+		return "", fmt.Errorf("%w: SCRAM server nonce doesn't extend the client nonce", ErrProtocol)
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad SCRAM salt: %v", ErrProtocol, err)
+	}
+	iterations, err := strconv.Atoi(iter)
+	if err != nil || iterations < 1 {
+		return "", fmt.Errorf("%w: bad SCRAM iteration count: %q", ErrProtocol, iter)
+	}
+
+	c.saltedPassword = scramHi(c.hash, []byte(c.password), salt, iterations)
+	clientKey := scramHMAC(c.hash, c.saltedPassword, []byte("Client Key"))
+	h := c.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	withoutProof := "c=biws,r=" + nonce
+	c.authMessage = c.clientFirstBare + "," + serverFirst + "," + withoutProof
+	signature := scramHMAC(c.hash, storedKey, []byte(c.authMessage))
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// VerifyServerFinal checks the server-final-message proves the server knows the password too
+func (c *scramClient) VerifyServerFinal(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("%w: SCRAM server error: %s", ErrAuth, e)
+	}
+	got, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return fmt.Errorf("%w: bad SCRAM server signature: %v", ErrProtocol, err)
+	}
+	serverKey := scramHMAC(c.hash, c.saltedPassword, []byte("Server Key"))
+	want := scramHMAC(c.hash, serverKey, []byte(c.authMessage))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return fmt.Errorf("%w: SCRAM server signature doesn't match", ErrProtocol)
+	}
+	return nil
+}
+
+// split a SCRAM message like "r=abc,s=xyz,i=4096" into its attributes
+func scramAttributes(msg string) map[string]string {
+	res := make(map[string]string)
+	for _, part := range strings.Split(msg, ",") {
+		if len(part) > 2 && part[1] == '=' {
+			res[part[:1]] = part[2:]
+		}
+	}
+	return res
+}
+
+func scramHMAC(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// scramHi is PBKDF2 with HMAC as the PRF and a single block of output, as SCRAM defines it
+func scramHi(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	block := make([]byte, 4)
+	binary.BigEndian.PutUint32(block, 1)
+	mac.Write(block)
+	u := mac.Sum(nil)
+	res := make([]byte, len(u))
+	copy(res, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range res {
+			res[j] ^= u[j]
+		}
+	}
+	return res
+}
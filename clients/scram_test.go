@@ -0,0 +1,95 @@
+package clients
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"testing"
+)
+
+// The example exchange from RFC 7677 section 3
+func TestScramClientRFC7677(t *testing.T) {
+	c := newScramClient(sha256.New, "user", "pencil")
+	c.clientNonce = "rOprNGfwEbeRWgbNEkqO"
+	if got := c.ClientFirst(); got != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("ClientFirst() = %s", got)
+	}
+	final, err := c.ClientFinal("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if final != want {
+		t.Errorf("ClientFinal() = %s; want %s", final, want)
+	}
+	if err := c.VerifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Error(err)
+	}
+	if err := c.VerifyServerFinal("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Error("a forged server signature should fail")
+	}
+	if err := c.VerifyServerFinal("e=invalid-proof"); !errors.Is(err, ErrAuth) {
+		t.Errorf("a server error should be an auth failure: %v", err)
+	}
+}
+
+func TestScramClientRejectsForeignNonce(t *testing.T) {
+	c := newScramClient(sha256.New, "user", "pencil")
+	c.ClientFirst()
+	if _, err := c.ClientFinal("r=someoneelse,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"); !errors.Is(err, ErrProtocol) {
+		t.Errorf("expected a protocol error, got %v", err)
+	}
+}
+
+// scramServer is the server side of SCRAM for the fake servers in the client tests
+type scramServer struct {
+	hash        func() hash.Hash
+	password    string
+	salt        []byte
+	iterations  int
+	clientBare  string
+	serverFirst string
+}
+
+func newScramServer(h func() hash.Hash, password string) *scramServer {
+	return &scramServer{hash: h, password: password, salt: []byte("preflight-salt"), iterations: 4096}
+}
+
+// First takes the client-first-message and returns the server-first-message
+func (s *scramServer) First(clientFirst string) string {
+	s.clientBare = strings.TrimPrefix(clientFirst, "n,,")
+	nonce := scramAttributes(s.clientBare)["r"] + "server-nonce"
+	s.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(s.salt), s.iterations)
+	return s.serverFirst
+}
+
+// Final checks the proof in the client-final-message and returns the server-final-message
+func (s *scramServer) Final(clientFinal string) (string, bool) {
+	i := strings.LastIndex(clientFinal, ",p=")
+	if i < 0 {
+		return "e=invalid-encoding", false
+	}
+	authMessage := s.clientBare + "," + s.serverFirst + "," + clientFinal[:i]
+	proof, _ := base64.StdEncoding.DecodeString(clientFinal[i+3:])
+
+	salted := scramHi(s.hash, []byte(s.password), s.salt, s.iterations)
+	clientKey := scramHMAC(s.hash, salted, []byte("Client Key"))
+	h := s.hash()
+	h.Write(clientKey)
+	signature := scramHMAC(s.hash, h.Sum(nil), []byte(authMessage))
+	if len(proof) != len(clientKey) {
+		return "e=invalid-proof", false
+	}
+	for j := range proof {
+		proof[j] ^= signature[j]
+	}
+	if !hmac.Equal(proof, clientKey) {
+		return "e=invalid-proof", false
+	}
+	serverKey := scramHMAC(s.hash, salted, []byte("Server Key"))
+	return "v=" + base64.StdEncoding.EncodeToString(scramHMAC(s.hash, serverKey, []byte(authMessage))), true
+}
@@ -0,0 +1,43 @@
+package clients
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPChecker only verifies that ADDRESS:PORT accepts a tcp connection. It's the fallback for client types that don't
+// have a protocol aware checker yet
+type TCPChecker struct {
+	ClientName   string
+	ClientPrefix string
+}
+
+func (c *TCPChecker) Name() string {
+	return c.ClientName
+}
+
+func (c *TCPChecker) Prefix() string {
+	return c.ClientPrefix
+}
+
+func (c *TCPChecker) RequiredFields() []string {
+	return []string{"ADDRESS", "PORT"}
+}
+
+func (c *TCPChecker) Run(ctx context.Context, host map[string]string) Result {
+	start := time.Now()
+	res := NewResult(host)
+	target := net.JoinHostPort(host["ADDRESS"], host["PORT"])
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		res = res.Fail(fmt.Errorf("unable to connect to %s: %w", target, err))
+	} else {
+		_ = conn.Close()
+		res = res.Pass(fmt.Sprintf("connected to %s", target))
+	}
+	res.Duration = time.Since(start)
+	return res
+}
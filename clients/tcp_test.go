@@ -0,0 +1,41 @@
+package clients
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTCPCheckerRun(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	c := &TCPChecker{ClientName: "test", ClientPrefix: "TEST"}
+	host := map[string]string{
+		"ID":      "LOCAL",
+		"CLIENT":  "TEST",
+		"ADDRESS": "127.0.0.1",
+		"PORT":    strconv.Itoa(addr.Port),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res := c.Run(ctx, host)
+	if !res.OK {
+		t.Errorf("expected tcp check to pass: %s", res.Message)
+	}
+	if res.ID != "LOCAL" || res.Client != "TEST" {
+		t.Errorf("result not labelled with the host: %+v", res)
+	}
+
+	// nothing listens once the listener is closed
+	_ = ln.Close()
+	res = c.Run(ctx, host)
+	if res.OK || res.Err == nil {
+		t.Error("expected tcp check to fail after the listener closed")
+	}
+}
@@ -0,0 +1,205 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Hosts of clients with TLSFields in their schema can ask for TLS with these fields:
+//   TLS=true            wrap the connection in TLS
+//   SERVERNAME=name     the name to verify the certificate against. defaults to ADDRESS
+//   CAFILE=/path        PEM bundle of CAs to trust instead of the system roots
+//   CLIENTCERT=/path    PEM client certificate for servers that require mutual TLS
+//   CLIENTKEY=/path     PEM private key for CLIENTCERT
+
+// with TLS 1.3 a server checks the client certificate after the client is done with the handshake, so a rejection
+// only shows up as an alert on the next read. InspectTLS waits this long for one
+const clientCertWait = 500 * time.Millisecond
+
+// StartTLS is implemented by checkers whose protocol upgrades a plain connection to TLS instead of speaking TLS from
+// the first byte. InspectTLS calls it on the new connection before the handshake
+type StartTLS interface {
+	StartTLS(conn net.Conn) error
+}
+
+// TLSEnabled reports whether the host's TLS field is set to a true value
+func TLSEnabled(host map[string]string) (bool, error) {
+	if host["TLS"] == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(host["TLS"])
+	if err != nil {
+		return false, fmt.Errorf("TLS must be true or false, not %q", host["TLS"])
+	}
+	return enabled, nil
+}
+
+// TLSConfig builds the client TLS config for a host from its SERVERNAME and CAFILE fields
+func TLSConfig(host map[string]string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: host["SERVERNAME"]}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host["ADDRESS"]
+	}
+	if host["CAFILE"] != "" {
+		pool, err := LoadCAFile(host["CAFILE"])
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if host["CLIENTCERT"] != "" || host["CLIENTKEY"] != "" {
+		cert, err := LoadClientCert(host)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LoadClientCert loads the CLIENTCERT and CLIENTKEY pair and checks that the key belongs to the certificate and that
+// the certificate is valid right now, so a bad pair is caught before any server sees it
+func LoadClientCert(host map[string]string) (tls.Certificate, error) {
+	if host["CLIENTCERT"] == "" || host["CLIENTKEY"] == "" {
+		return tls.Certificate{}, fmt.Errorf("CLIENTCERT and CLIENTKEY have to be set together")
+	}
+	certPEM, err := ioutil.ReadFile(host["CLIENTCERT"])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read client certificate: %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(host["CLIENTKEY"])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("unable to read client key: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: client certificate %s and key %s: %v", ErrTLS, host["CLIENTCERT"],
+			host["CLIENTKEY"], err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%w: client certificate %s: %v", ErrTLS, host["CLIENTCERT"], err)
+	}
+	now := time.Now()
+	if now.Before(leaf.NotBefore) {
+		return tls.Certificate{}, fmt.Errorf("%w: client certificate %s isn't valid until %s", ErrTLS, host["CLIENTCERT"],
+			leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return tls.Certificate{}, fmt.Errorf("%w: client certificate %s expired on %s", ErrTLS, host["CLIENTCERT"],
+			leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// LoadCAFile reads a PEM bundle of CA certificates into a pool
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// Handshake runs the client side of a TLS handshake on conn. Failures are ErrTLS, so a bad certificate isn't mistaken
+// for a network problem
+func Handshake(conn net.Conn, cfg *tls.Config, target string) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("%w: TLS handshake with %s failed: %v", ErrTLS, target, err)
+	}
+	return tlsConn, nil
+}
+
+// IsTLSError reports whether err came from verifying the server's certificate or from the server refusing ours.
+// Clients that let another package run the handshake, like net/http, use it to tell certificate problems from network
+// ones
+func IsTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var header tls.RecordHeaderError
+	var op *net.OpError
+	// crypto/tls reports an alert from the server, like "bad certificate", as a "remote error"
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &header) || (errors.As(err, &op) && op.Op == "remote error")
+}
+
+// InspectTLS connects to the endpoint, runs a verified handshake and returns the server's leaf certificate. The name
+// checked against the certificate is SERVERNAME, then ADDRESS, then the endpoint's address. With CLIENTCERT set it
+// also makes sure the server accepted the client certificate
+func InspectTLS(ctx context.Context, host map[string]string, endpoint Endpoint) (*x509.Certificate, error) {
+	cfg, err := TLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = endpoint.Address
+	}
+	// only wait for a verdict on the client certificate from servers that ask for one
+	requested := false
+	certs := cfg.Certificates
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		requested = true
+		if len(certs) == 0 {
+			return &tls.Certificate{}, nil
+		}
+		return &certs[0], nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", endpoint.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to connect to %s: %v", ErrNetwork, endpoint, err)
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if c, ok := Lookup(host["CLIENT"]); ok {
+		if s, ok := c.(StartTLS); ok {
+			if err := s.StartTLS(conn); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tlsConn, err := Handshake(conn, cfg, endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	if requested {
+		if err := confirmClientCert(tlsConn, endpoint.String()); err != nil {
+			return nil, err
+		}
+	}
+	peer := tlsConn.ConnectionState().PeerCertificates
+	if len(peer) == 0 {
+		return nil, fmt.Errorf("%w: %s sent no certificate", ErrTLS, endpoint)
+	}
+	return peer[0], nil
+}
+
+// confirmClientCert waits briefly for the server to reject the client certificate, or the lack of one. Before TLS 1.3
+// that happens during the handshake, so there's nothing to wait for. Anything but an alert, including a timeout, means
+// the server is happy
+func confirmClientCert(conn *tls.Conn, target string) error {
+	if conn.ConnectionState().Version < tls.VersionTLS13 {
+		return nil
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(clientCertWait))
+	if _, err := conn.Read(make([]byte, 1)); err != nil && IsTLSError(err) {
+		return fmt.Errorf("%w: %s rejected the client certificate (CLIENTCERT): %v", ErrTLS, target, err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCA is a throwaway certificate authority for the TLS tests
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	caFile string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "preflight test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	f, err := ioutil.TempFile("", "preflight-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	_ = f.Close()
+	return &testCA{cert: cert, key: key, caFile: f.Name()}
+}
+
+func (ca *testCA) Close() {
+	_ = os.Remove(ca.caFile)
+}
+
+// issue signs a certificate for "localhost" and 127.0.0.1 that's valid until notAfter
+func (ca *testCA) issue(t *testing.T, notAfter time.Time, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// serverConfig is a TLS server config with a certificate from the CA
+func (ca *testCA) serverConfig(t *testing.T) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{ca.issue(t, time.Now().Add(24*time.Hour), x509.ExtKeyUsageServerAuth)}}
+}
+
+func TestTLSEnabled(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "true": true, "TRUE": true, "1": true, "false": false} {
+		got, err := TLSEnabled(map[string]string{"TLS": value})
+		if err != nil || got != want {
+			t.Errorf("TLSEnabled(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	if _, err := TLSEnabled(map[string]string{"TLS": "yes please"}); err == nil {
+		t.Error("expected an error for a TLS value that isn't a bool")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+
+	cfg, err := TLSConfig(map[string]string{"ADDRESS": "db.domain.com", "CAFILE": ca.caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerName != "db.domain.com" || cfg.RootCAs == nil {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	cfg, _ = TLSConfig(map[string]string{"ADDRESS": "10.0.0.1", "SERVERNAME": "db.domain.com"})
+	if cfg.ServerName != "db.domain.com" {
+		t.Errorf("SERVERNAME should override ADDRESS, got %s", cfg.ServerName)
+	}
+	if _, err := TLSConfig(map[string]string{"CAFILE": "/nonexistent/ca.pem"}); err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+}
+
+// writeCert writes a certificate and its key to PEM files and returns their paths
+func writeCert(t *testing.T, cert tls.Certificate) (string, string) {
+	certFile, err := ioutil.TempFile("", "preflight-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	_ = certFile.Close()
+	der, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := ioutil.TempFile("", "preflight-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	_ = keyFile.Close()
+	return certFile.Name(), keyFile.Name()
+}
+
+func TestLoadClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	certFile, keyFile := writeCert(t, ca.issue(t, time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	defer func() { _ = os.Remove(certFile); _ = os.Remove(keyFile) }()
+	_, otherKey := writeCert(t, ca.issue(t, time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	defer func() { _ = os.Remove(otherKey) }()
+	expiredCert, expiredKey := writeCert(t, ca.issue(t, time.Now().Add(-time.Minute), x509.ExtKeyUsageClientAuth))
+	defer func() { _ = os.Remove(expiredCert); _ = os.Remove(expiredKey) }()
+
+	cert, err := LoadClientCert(map[string]string{"CLIENTCERT": certFile, "CLIENTKEY": keyFile})
+	if err != nil || cert.Leaf == nil {
+		t.Fatalf("expected the pair to load: %v", err)
+	}
+	cfg, err := TLSConfig(map[string]string{"ADDRESS": "db.domain.com", "CLIENTCERT": certFile, "CLIENTKEY": keyFile})
+	if err != nil || len(cfg.Certificates) != 1 {
+		t.Errorf("expected TLSConfig to carry the client certificate: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		host map[string]string
+		kind error
+	}{
+		{"key only", map[string]string{"CLIENTKEY": keyFile}, nil},
+		{"missing file", map[string]string{"CLIENTCERT": "/nonexistent/cert.pem", "CLIENTKEY": keyFile}, nil},
+		{"key mismatch", map[string]string{"CLIENTCERT": certFile, "CLIENTKEY": otherKey}, ErrTLS},
+		{"not a certificate", map[string]string{"CLIENTCERT": keyFile, "CLIENTKEY": keyFile}, ErrTLS},
+		{"expired", map[string]string{"CLIENTCERT": expiredCert, "CLIENTKEY": expiredKey}, ErrTLS},
+	}
+	for _, c := range cases {
+		_, err := LoadClientCert(c.host)
+		if err == nil || (c.kind != nil && !errors.Is(err, c.kind)) {
+			t.Errorf("%s: expected an error of kind %v, got %v", c.name, c.kind, err)
+		}
+	}
+}
+
+// a server that requires client certificates from the CA accepts the CA's certificate and rejects one from another
+// CA, with TLS 1.2 where the handshake fails and with TLS 1.3 where the rejection comes after it
+func TestInspectTLSClientCert(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	other := newTestCA(t)
+	defer other.Close()
+	goodCert, goodKey := writeCert(t, ca.issue(t, time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	defer func() { _ = os.Remove(goodCert); _ = os.Remove(goodKey) }()
+	badCert, badKey := writeCert(t, other.issue(t, time.Now().Add(time.Hour), x509.ExtKeyUsageClientAuth))
+	defer func() { _ = os.Remove(badCert); _ = os.Remove(badKey) }()
+	clientCAs, _ := LoadCAFile(ca.caFile)
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		cfg := ca.serverConfig(t)
+		cfg.ClientAuth, cfg.ClientCAs, cfg.MinVersion, cfg.MaxVersion = tls.RequireAndVerifyClientCert, clientCAs, version, version
+		// hold the connection open like a server waiting for the client to speak first
+		srv := startFakeTLSServer(t, cfg, func(conn net.Conn) { _, _ = io.Copy(ioutil.Discard, conn) })
+		address, port, _ := net.SplitHostPort(srv.addr())
+		endpoint := Endpoint{Address: address, Port: port}
+
+		for _, c := range []struct {
+			name       string
+			cert, key  string
+			wantErrTLS bool
+		}{
+			{"accepted", goodCert, goodKey, false},
+			{"rejected", badCert, badKey, true},
+			{"no client certificate", "", "", true},
+		} {
+			host := map[string]string{"ADDRESS": "localhost", "CAFILE": ca.caFile, "CLIENTCERT": c.cert, "CLIENTKEY": c.key}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := InspectTLS(ctx, host, endpoint)
+			cancel()
+			if c.wantErrTLS != errors.Is(err, ErrTLS) || (!c.wantErrTLS && err != nil) {
+				t.Errorf("TLS %x %s: unexpected result %v", version, c.name, err)
+			}
+		}
+		srv.Close()
+	}
+}
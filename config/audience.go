@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Preflight logs are for whoever has to fix the problem, so every entry is tagged with an audience like
+// [MyCompanyName:DevOps] from the 'organization' and 'team' config keys. The 'audiences' config key overrides the
+// team for specific checks. Keys are env var names, host IDs or client prefixes, and values are a team or
+// organization:team:
+//
+// audiences:
+//   POSTGRES10: DBA              # every Postgres host
+//   HOT_PICKLES: MyOtherOrg:DBA  # one host
+//   FEATURE_X: Payments          # one env var
+
+// AudienceHook adds the audience tag to the message and the organization, team and audience fields to every entry.
+// An entry that already has a "team" or "organization" field (see AudienceFields) keeps it
+type AudienceHook struct{}
+
+func (h *AudienceHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *AudienceHook) Fire(entry *log.Entry) error {
+	org, team := viper.GetString("organization"), viper.GetString("team")
+	if v, ok := entry.Data["organization"].(string); ok && v != "" {
+		org = v
+	}
+	if v, ok := entry.Data["team"].(string); ok && v != "" {
+		team = v
+	}
+	audience := fmt.Sprintf("%s:%s", org, team)
+	entry.Data["organization"] = org
+	entry.Data["team"] = team
+	entry.Data["audience"] = audience
+
+	tag := "[" + audience + "]"
+	if !strings.HasPrefix(entry.Message, tag) {
+		entry.Message = tag + " " + entry.Message
+	}
+	return nil
+}
+
+// Return the log fields that route a message about any of the keys to the team configured for it in 'audiences'. The
+// first key with an override wins, so pass the most specific key first. No override means no fields, and the hook
+// falls back to the default team
+func AudienceFields(keys ...string) log.Fields {
+	// viper lower cases the keys it reads from the config file, so compare without case
+	overrides := make(map[string]string)
+	for k, v := range viper.GetStringMapString("audiences") {
+		overrides[strings.ToLower(k)] = v
+	}
+	for _, key := range keys {
+		value, ok := overrides[strings.ToLower(key)]
+		if !ok || value == "" {
+			continue
+		}
+		fields := log.Fields{"team": value}
+		if i := strings.Index(value, ":"); i >= 0 {
+			fields["organization"], fields["team"] = value[:i], value[i+1:]
+		}
+		return fields
+	}
+	return log.Fields{}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+func audienceLogger() (*log.Logger, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	// the audience hook has to fire before the recording hook sees the entry
+	logger.ReplaceHooks(make(log.LevelHooks))
+	logger.AddHook(&AudienceHook{})
+	logger.AddHook(hook)
+	return logger, hook
+}
+
+func TestAudienceHookDefault(t *testing.T) {
+	viper.Set("organization", "MyCompanyName")
+	viper.Set("team", "DevOps")
+	logger, hook := audienceLogger()
+
+	logger.Error("something broke")
+	e := hook.LastEntry()
+	if e.Message != "[MyCompanyName:DevOps] something broke" {
+		t.Errorf("message = %q", e.Message)
+	}
+	if e.Data["organization"] != "MyCompanyName" || e.Data["team"] != "DevOps" || e.Data["audience"] != "MyCompanyName:DevOps" {
+		t.Errorf("fields = %v", e.Data)
+	}
+}
+
+func TestAudienceFieldsOverride(t *testing.T) {
+	viper.Set("organization", "MyCompanyName")
+	viper.Set("team", "DevOps")
+	viper.Set("audiences", map[string]string{
+		"POSTGRES10":  "DBA",
+		"HOT_PICKLES": "PickleCorp:Brine",
+		"FEATURE_X":   "Payments",
+	})
+	defer viper.Set("audiences", map[string]string{})
+	logger, hook := audienceLogger()
+
+	logger.WithFields(AudienceFields("FEATURE_X")).Error("environment variable key does not exist: FEATURE_X")
+	if hook.LastEntry().Message != "[MyCompanyName:Payments] environment variable key does not exist: FEATURE_X" {
+		t.Errorf("message = %q", hook.LastEntry().Message)
+	}
+
+	// the host ID is more specific than the client type
+	logger.WithFields(AudienceFields("HOT_PICKLES", "POSTGRES10")).Error("login failed")
+	if hook.LastEntry().Message != "[PickleCorp:Brine] login failed" {
+		t.Errorf("message = %q", hook.LastEntry().Message)
+	}
+	logger.WithFields(AudienceFields("COLD_PICKLES", "POSTGRES10")).Error("login failed")
+	if hook.LastEntry().Message != "[MyCompanyName:DBA] login failed" {
+		t.Errorf("message = %q", hook.LastEntry().Message)
+	}
+
+	if len(AudienceFields("NOBODY_CARES")) != 0 {
+		t.Error("no override should mean no fields")
+	}
+}
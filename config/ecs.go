@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ECS injects the metadata endpoint into every container: ECS_CONTAINER_METADATA_URI_V4 on platform 1.4+ / agent
+// 1.39+, ECS_CONTAINER_METADATA_URI (v3) on older ones. ${uri} returns the container's own metadata and ${uri}/task
+// returns the task's.
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/task-metadata-endpoint-v4.html
+
+const (
+	ECSMetadataEnvV4   string = "ECS_CONTAINER_METADATA_URI_V4"
+	ECSMetadataEnvV3   string = "ECS_CONTAINER_METADATA_URI"
+	ECSMetadataTimeout        = 2 * time.Second
+)
+
+// ECSContainer is the subset of the container metadata response preflight logs
+type ECSContainer struct {
+	Name     string            `json:"Name"`
+	Image    string            `json:"Image"`
+	ImageID  string            `json:"ImageID"`
+	Labels   map[string]string `json:"Labels"`
+	Networks []struct {
+		NetworkMode   string   `json:"NetworkMode"`
+		IPv4Addresses []string `json:"IPv4Addresses"`
+	} `json:"Networks"`
+}
+
+// ECSTask is the subset of the task metadata response preflight logs
+type ECSTask struct {
+	Cluster          string `json:"Cluster"`
+	TaskARN          string `json:"TaskARN"`
+	Family           string `json:"Family"`
+	Revision         string `json:"Revision"`
+	AvailabilityZone string `json:"AvailabilityZone"`
+}
+
+// Return the metadata endpoint, preferring v4. An empty string means we aren't running in ECS
+func ECSMetadataURI() string {
+	if uri := os.Getenv(ECSMetadataEnvV4); uri != "" {
+		return uri
+	}
+	return os.Getenv(ECSMetadataEnvV3)
+}
+
+// Fetch the container and task metadata from the endpoint and flatten it into log fields
+func GetECSMetadata(uri string) (log.Fields, error) {
+	client := &http.Client{Timeout: ECSMetadataTimeout}
+	var container ECSContainer
+	if err := getJSON(client, uri, &container); err != nil {
+		return nil, err
+	}
+	var task ECSTask
+	if err := getJSON(client, strings.TrimRight(uri, "/")+"/task", &task); err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, n := range container.Networks {
+		ips = append(ips, n.IPv4Addresses...)
+	}
+	cluster := task.Cluster
+	if cluster == "" {
+		cluster = container.Labels["com.amazonaws.ecs.cluster"]
+	}
+	return log.Fields{
+		"platform":          "ecs",
+		"container_name":    container.Name,
+		"image":             container.Image,
+		"image_digest":      container.ImageID,
+		"task_arn":          task.TaskARN,
+		"task_family":       fmt.Sprintf("%s:%s", task.Family, task.Revision),
+		"cluster":           cluster,
+		"availability_zone": task.AvailabilityZone,
+		"container_ips":     strings.Join(ips, ","),
+	}, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to parse response from %s: %v", url, err)
+	}
+	return nil
+}
+
+// Log the ECS container and task metadata. Do nothing when the metadata endpoint isn't set because we're not in ECS
+func LogECSMetadata() {
+	uri := ECSMetadataURI()
+	if uri == "" {
+		log.Debug("No ECS metadata endpoint set. Skipping ECS metadata")
+		return
+	}
+	fields, err := GetECSMetadata(uri)
+	if err != nil {
+		log.Warn(fmt.Sprintf("Unable to get ECS metadata: %v", err))
+		return
+	}
+	log.WithFields(fields).Info("ECS container metadata")
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// canned responses trimmed from the v4 documentation examples
+const ecsContainerJSON = `{
+	"DockerId": "ea32192c8553fbff06c9340478a2ff089b2bb5646fb718b4ee206641c9086d66",
+	"Name": "curl",
+	"Image": "111122223333.dkr.ecr.us-west-2.amazonaws.com/curltest:latest",
+	"ImageID": "sha256:d691691e9652791a60114e67b365688d20d19940dde7c4736ea30e660d8d3553",
+	"Labels": {
+		"com.amazonaws.ecs.cluster": "arn:aws:ecs:us-west-2:111122223333:cluster/default",
+		"com.amazonaws.ecs.task-arn": "arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c"
+	},
+	"Networks": [{"NetworkMode": "awsvpc", "IPv4Addresses": ["10.0.2.106"]}]
+}`
+
+const ecsTaskJSON = `{
+	"Cluster": "arn:aws:ecs:us-west-2:111122223333:cluster/default",
+	"TaskARN": "arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+	"Family": "curltest",
+	"Revision": "26",
+	"AvailabilityZone": "us-west-2d"
+}`
+
+func ecsServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v4/abc", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ecsContainerJSON))
+	})
+	mux.HandleFunc("/v4/abc/task", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ecsTaskJSON))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGetECSMetadata(t *testing.T) {
+	srv := ecsServer()
+	defer srv.Close()
+
+	fields, err := GetECSMetadata(srv.URL + "/v4/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"image":             "111122223333.dkr.ecr.us-west-2.amazonaws.com/curltest:latest",
+		"image_digest":      "sha256:d691691e9652791a60114e67b365688d20d19940dde7c4736ea30e660d8d3553",
+		"task_arn":          "arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+		"cluster":           "arn:aws:ecs:us-west-2:111122223333:cluster/default",
+		"availability_zone": "us-west-2d",
+		"container_ips":     "10.0.2.106",
+		"task_family":       "curltest:26",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("fields[%s] = %v; want %s", k, fields[k], v)
+		}
+	}
+}
+
+func TestGetECSMetadataBadEndpoint(t *testing.T) {
+	srv := ecsServer()
+	defer srv.Close()
+	if _, err := GetECSMetadata(srv.URL + "/v4/nope"); err == nil {
+		t.Fail()
+	}
+}
+
+// V4 wins over v3 when both are set
+func TestLogECSMetadata(t *testing.T) {
+	srv := ecsServer()
+	defer srv.Close()
+	_ = os.Setenv(ECSMetadataEnvV3, srv.URL+"/v3/wrong")
+	_ = os.Setenv(ECSMetadataEnvV4, srv.URL+"/v4/abc")
+	defer func() {
+		_ = os.Unsetenv(ECSMetadataEnvV3)
+		_ = os.Unsetenv(ECSMetadataEnvV4)
+	}()
+
+	hook := test.NewGlobal()
+	LogECSMetadata()
+	if hook.LastEntry() == nil || hook.LastEntry().Message != "ECS container metadata" {
+		t.Fatal("expected the ECS metadata to be logged")
+	}
+	if hook.LastEntry().Data["task_arn"] == "" {
+		t.Fail()
+	}
+}
+
+// Outside of ECS there's nothing to log above debug
+func TestLogECSMetadataSkipped(t *testing.T) {
+	_ = os.Unsetenv(ECSMetadataEnvV3)
+	_ = os.Unsetenv(ECSMetadataEnvV4)
+	hook := test.NewGlobal()
+	LogECSMetadata()
+	for _, e := range hook.AllEntries() {
+		if e.Level <= log.WarnLevel {
+			t.Errorf("unexpected log entry: %s", e.Message)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Host checks spend nearly all of their time waiting on the network, so they run concurrently. The 'parallelism'
+// config key caps how many hosts are checked at once. Checks for a single host still run in order (resolve, connect,
+// client access) because each step is pointless when the one before it failed.
+
+// Return the configured number of hosts to check at once. Anything less than 1 means 1
+func Parallelism() int {
+	p := viper.GetInt("parallelism")
+	if p < 1 {
+		return 1
+	}
+	return p
+}
+
+// Return the host IDs in sorted order so logs and reports come out the same way every run
+func SortedHostIDs(hosts map[string]map[string]string) []string {
+	res := make([]string, 0, len(hosts))
+	for id := range hosts {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Run check against every host map using a pool of Parallelism() workers. Return the result for each host ID
+func runHostChecks(hosts map[string]map[string]string, check func(map[string]string) bool) map[string]bool {
+	ids := SortedHostIDs(hosts)
+	results := make([]bool, len(ids))
+
+	workers := Parallelism()
+	if workers > len(ids) {
+		workers = len(ids)
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each worker owns the slot for the index it was handed, so results needs no lock
+			for i := range jobs {
+				results[i] = check(hosts[ids[i]])
+			}
+		}()
+	}
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	res := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		res[id] = results[i]
+	}
+	return res
+}
+
+// Run check against every host concurrently and return the hosts that passed and a boolean that's only true if they
+// all did. See Host Data Filter Pipeline in settings.go
+func filterHosts(hosts map[string]map[string]string, check func(map[string]string) bool) (map[string]map[string]string, bool) {
+	success := true
+	res := make(map[string]map[string]string)
+	for id, ok := range runHostChecks(hosts, check) {
+		if ok {
+			res[id] = hosts[id]
+		} else {
+			success = false
+		}
+	}
+	return res, success
+}
+
+// Run the whole pipeline (resolve, connect, TLS, client access) for each host concurrently, so the total time is about
+// the time of the slowest host instead of the sum of all of them. Log a summary in host ID order once every host is
+// done. Return the hosts that passed everything and a boolean that's only true if they all did
+func CheckHosts(hosts map[string]map[string]string) (map[string]map[string]string, bool) {
+	results := runHostChecks(hosts, func(hMap map[string]string) bool {
+		if !IsReachable(hMap) || !InspectHostTLS(hMap) {
+			rec := hostRecord(report.Client, hMap, false, 0)
+			rec.Status = report.Skip
+			report.Add(rec)
+			return false
+		}
+		return VerifyHostAccess(hMap)
+	})
+
+	success := true
+	res := make(map[string]map[string]string)
+	for _, id := range SortedHostIDs(hosts) {
+		entry := log.WithFields(hostAudience(hosts[id]))
+		if results[id] {
+			res[id] = hosts[id]
+			entry.Info(fmt.Sprintf("Host check summary: %s (%s) passed", id, hosts[id]["CLIENT"]))
+		} else {
+			success = false
+			entry.Error(fmt.Sprintf("Host check summary: %s (%s) failed", id, hosts[id]["CLIENT"]))
+		}
+	}
+	return res, success
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+// slowChecker takes a while to pass and keeps track of how many runs overlap
+type slowChecker struct {
+	mu      sync.Mutex
+	running int
+	peak    int
+}
+
+func (c *slowChecker) Name() string             { return "slow test" }
+func (c *slowChecker) Prefix() string           { return "SLOWTEST" }
+func (c *slowChecker) RequiredFields() []string { return nil }
+func (c *slowChecker) Run(ctx context.Context, host map[string]string) clients.Result {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.peak {
+		c.peak = c.running
+	}
+	c.mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	if host["FAIL"] != "" {
+		return clients.NewResult(host).Fail(fmt.Errorf("told to fail"))
+	}
+	return clients.NewResult(host).Pass("slow")
+}
+
+var slow = &slowChecker{}
+
+func init() {
+	clients.Register(slow)
+}
+
+func slowHosts(n int) map[string]map[string]string {
+	res := make(map[string]map[string]string)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("HOST%02d", i)
+		res[id] = map[string]string{"ID": id, "CLIENT": "SLOWTEST"}
+	}
+	return res
+}
+
+// Ten 200ms checks with parallelism 10 take about as long as one of them
+func TestVerifyClientAccessConcurrent(t *testing.T) {
+	viper.Set("parallelism", 10)
+	defer viper.Set("parallelism", DefaultParallelism)
+
+	start := time.Now()
+	res, ok := VerifyClientAccess(slowHosts(10))
+	elapsed := time.Since(start)
+	if !ok || len(res) != 10 {
+		t.Errorf("expected all 10 hosts to pass, got %d", len(res))
+	}
+	if elapsed > time.Second {
+		t.Errorf("checks didn't run concurrently: took %s", elapsed)
+	}
+}
+
+func TestVerifyClientAccessParallelismLimit(t *testing.T) {
+	viper.Set("parallelism", 3)
+	defer viper.Set("parallelism", DefaultParallelism)
+	slow.peak = 0
+
+	hosts := slowHosts(9)
+	hosts["HOST04"]["FAIL"] = "yes"
+	res, ok := VerifyClientAccess(hosts)
+	if ok || len(res) != 8 {
+		t.Errorf("expected 8 of 9 hosts to pass, got %d", len(res))
+	}
+	if _, found := res["HOST04"]; found {
+		t.Error("the failing host should be filtered out")
+	}
+	if slow.peak > 3 {
+		t.Errorf("ran %d checks at once with parallelism 3", slow.peak)
+	}
+}
+
+func TestParallelismFloor(t *testing.T) {
+	viper.Set("parallelism", 0)
+	defer viper.Set("parallelism", DefaultParallelism)
+	if Parallelism() != 1 {
+		t.Errorf("Parallelism() = %d; want 1", Parallelism())
+	}
+}
+
+// The summary is logged in host ID order no matter which host finishes first
+func TestCheckHostsSummaryOrder(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	hosts := slowHosts(4)
+	for _, hMap := range hosts {
+		hMap["ADDRESS"] = "127.0.0.1"
+		hMap["PORT"] = port
+	}
+	hosts["HOST02"]["FAIL"] = "yes"
+
+	hook := test.NewGlobal()
+	res, ok := CheckHosts(hosts)
+	if ok || len(res) != 3 {
+		t.Errorf("expected 3 of 4 hosts to pass, got %d", len(res))
+	}
+
+	var summary []string
+	for _, e := range hook.AllEntries() {
+		if len(e.Message) > 20 && e.Message[:20] == "Host check summary: " {
+			summary = append(summary, e.Message)
+		}
+	}
+	want := []string{
+		"Host check summary: HOST00 (SLOWTEST) passed",
+		"Host check summary: HOST01 (SLOWTEST) passed",
+		"Host check summary: HOST02 (SLOWTEST) failed",
+		"Host check summary: HOST03 (SLOWTEST) passed",
+	}
+	if fmt.Sprint(summary) != fmt.Sprint(want) {
+		t.Errorf("summary = %v; want %v", summary, want)
+	}
+}
+
+// An unreachable host gets a failed tcp record and a skipped client record
+func TestCheckHostsReport(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+
+	hosts := slowHosts(1)
+	hosts["HOST00"]["ADDRESS"] = "127.0.0.1"
+	hosts["HOST00"]["PORT"] = strconv.Itoa(closed.Addr().(*net.TCPAddr).Port)
+
+	rep := report.Reset("test")
+	CheckHosts(hosts)
+	rep.Finish()
+
+	want := map[string]report.Status{
+		"resolve:HOST00": report.Pass,
+		"tcp:HOST00":     report.Fail,
+		"client:HOST00":  report.Skip,
+	}
+	if len(rep.Records) != len(want) {
+		t.Fatalf("got records %+v", rep.Records)
+	}
+	for _, rec := range rep.Records {
+		if want[rec.Name] != rec.Status || rec.HostID != "HOST00" || rec.Client != "SLOWTEST" {
+			t.Errorf("unexpected record: %+v", rec)
+		}
+	}
+	if rep.Success {
+		t.Fail()
+	}
+}
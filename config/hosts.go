@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Hosts can also be declared in the 'hosts' config section, for services whose env vars don't follow the
+// CLIENT_ID_FIELD naming. Field values are literals or ${VAR} references to environment variables:
+//
+//	hosts:
+//	  - id: BILLING_DB
+//	    client: POSTGRES10
+//	    fields:
+//	      address: ${BILLING_DB_HOST}
+//	      port: 5432
+//	      username: ${BILLING_DB_USER}
+//	      password: ${BILLING_DB_PASSWORD}
+//	  - id: SESSIONS
+//	    url: ${SESSION_STORE}        # a connection URL, see urls.go. sets the client too
+//
+// Field names are upper cased like the env var ones. A host that's also set with env vars gets both sets of fields,
+// and the env vars win. Hosts from here and from connection URLs get a SOURCE field naming where they were declared.
+// Like RESOLVED_ADDRESS it's set by preflight, so config errors can point at the entry instead of at env vars
+
+// a ${VAR} reference in a host field
+var hostVarRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Return the hosts from the 'hosts' config section as host maps by ID. ${VAR} references are looked up in envVars
+// first, so defaults from 'checked_environment_variables' apply, then in the environment. A host with a bad entry or
+// a reference to a variable that isn't set fails a config check and is left out
+func GetConfigHosts(envVars map[string]string) map[string]map[string]string {
+	res := make(map[string]map[string]string)
+	entries, ok := viper.Get("hosts").([]interface{})
+	if !ok {
+		if viper.IsSet("hosts") {
+			hostConfigFail("hosts", fmt.Errorf("'hosts' has to be a list of hosts"))
+		}
+		return res
+	}
+	for i, entry := range entries {
+		hMap, err := ParseConfigHost(entry, envVars)
+		if err != nil {
+			name := hMap["ID"]
+			if name == "" {
+				name = fmt.Sprintf("entry %d", i+1)
+			}
+			hostConfigFail(name, err)
+			continue
+		}
+		if _, ok := res[hMap["ID"]]; ok {
+			hostConfigFail(hMap["ID"], fmt.Errorf("host %s is declared more than once", hMap["ID"]))
+			continue
+		}
+		hMap["SOURCE"] = fmt.Sprintf("host %s in 'hosts'", hMap["ID"])
+		res[hMap["ID"]] = hMap
+	}
+	return res
+}
+
+// Build a host map from a 'hosts' entry. The map has the ID even when there's an error
+func ParseConfigHost(entry interface{}, envVars map[string]string) (map[string]string, error) {
+	hMap := make(map[string]string)
+	fields, ok := configMap(entry)
+	if !ok {
+		return hMap, fmt.Errorf("host has to be a map with id, client and fields")
+	}
+	var hostFields map[string]interface{}
+	var connURL interface{}
+	for k, v := range fields {
+		switch strings.ToLower(k) {
+		case "id":
+			hMap["ID"] = fmt.Sprint(v)
+		case "client":
+			hMap["CLIENT"] = strings.ToUpper(fmt.Sprint(v))
+		case "url":
+			connURL = v
+		case "fields":
+			if hostFields, ok = configMap(v); !ok {
+				return hMap, fmt.Errorf("fields has to be a map of field names to values")
+			}
+		default:
+			return hMap, fmt.Errorf("unknown key %q, hosts have id, client, url and fields", k)
+		}
+	}
+	if hMap["ID"] == "" {
+		return hMap, fmt.Errorf("host has no id")
+	}
+
+	var missing []string
+	expand := func(name string, v interface{}) string {
+		return hostVarRef.ReplaceAllStringFunc(fmt.Sprint(v), func(ref string) string {
+			key := hostVarRef.FindStringSubmatch(ref)[1]
+			if val, ok := envVars[key]; ok {
+				return val
+			}
+			if val, ok := os.LookupEnv(key); ok {
+				return val
+			}
+			missing = append(missing, fmt.Sprintf("%s (%s)", key, name))
+			return ""
+		})
+	}
+	for k, v := range hostFields {
+		name := strings.ToUpper(k)
+		if name == "ID" || name == "CLIENT" {
+			return hMap, fmt.Errorf("%s is set outside of fields", name)
+		}
+		hMap[name] = expand(name, v)
+	}
+	var urlFields map[string]string
+	var tlsIgnored []string
+	if connURL != nil {
+		raw := expand("url", connURL)
+		if len(missing) == 0 {
+			var err error
+			if urlFields, tlsIgnored, err = parseConnURL(raw); err != nil {
+				return hMap, err
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return hMap, fmt.Errorf("referenced environment variables aren't set: %s", strings.Join(missing, ", "))
+	}
+
+	// fields win over the ones from the url
+	for k, v := range urlFields {
+		if k == "CLIENT" && hMap["CLIENT"] != "" && hMap["CLIENT"] != v {
+			return hMap, fmt.Errorf("client is %s, but the url is for %s", hMap["CLIENT"], v)
+		}
+		if _, ok := hMap[k]; !ok {
+			hMap[k] = v
+		}
+	}
+	if _, ok := clients.Lookup(hMap["CLIENT"]); !ok {
+		return hMap, fmt.Errorf("client must be one of %s, not %q", strings.Join(clients.Prefixes(), ", "),
+			hMap["CLIENT"])
+	}
+	if len(tlsIgnored) > 0 {
+		hostTLSIgnored(hMap["ID"], fmt.Sprintf("the url of host %s in 'hosts'", hMap["ID"]), hMap["CLIENT"], tlsIgnored)
+	}
+	return hMap, nil
+}
+
+// Add the fields of the hosts in extra to hosts. Fields already in hosts win. A host with a different client in each
+// fails a config check and keeps the one in hosts
+func MergeHosts(hosts, extra map[string]map[string]string) {
+	for id, hMap := range extra {
+		existing, ok := hosts[id]
+		if !ok {
+			hosts[id] = hMap
+			continue
+		}
+		if existing["CLIENT"] != hMap["CLIENT"] {
+			hostConfigFail(id, fmt.Errorf("host %s is already a %s, it can't be a %s too", id, existing["CLIENT"],
+				hMap["CLIENT"]))
+			continue
+		}
+		for k, v := range hMap {
+			if _, ok := existing[k]; !ok {
+				existing[k] = v
+			}
+		}
+	}
+}
+
+// yaml gives maps with interface keys, json and viper.Set give string keys
+func configMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[fmt.Sprint(k)] = v
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+func hostConfigFail(name string, err error) {
+	log.Error(fmt.Sprintf("bad host in 'hosts' for %s: %v", name, err))
+	report.Add(report.Record{
+		Name:        "config:hosts:" + name,
+		Category:    report.Config,
+		Status:      report.Fail,
+		Error:       err.Error(),
+		Remediation: fmt.Sprintf("fix the entry for %s in 'hosts'", name),
+	})
+}
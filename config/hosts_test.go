@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/natemarks/preflight/report"
+	"github.com/spf13/viper"
+)
+
+func TestGetConfigHosts(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	err := v.ReadConfig(bytes.NewBufferString(`
+hosts:
+  - id: BILLING_DB
+    client: postgres10
+    fields:
+      address: ${BILLING_DB_HOST}
+      port: 5432
+      username: ${BILLING_DB_USER}
+      database: billing_${DEPLOYMENT_COLOR}
+  - id: CACHE
+    client: REDIS
+    fields:
+      address: ${CACHE_HOST}
+  - id: QUEUE
+    client: NOTACLIENT
+  - client: REDIS
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := viper.Get("hosts")
+	defer viper.Set("hosts", saved)
+	viper.Set("hosts", v.Get("hosts"))
+	_ = os.Setenv("BILLING_DB_HOST", "db.domain.com")
+	_ = os.Unsetenv("CACHE_HOST")
+	defer func() { _ = os.Unsetenv("BILLING_DB_HOST") }()
+
+	rep := report.Reset("test")
+	// BILLING_DB_USER comes from the checked variables, like a default would
+	hosts := GetConfigHosts(map[string]string{"BILLING_DB_USER": "pat", "DEPLOYMENT_COLOR": "red"})
+	rep.Finish()
+
+	want := map[string]string{"ID": "BILLING_DB", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com", "PORT": "5432",
+		"USERNAME": "pat", "DATABASE": "billing_red", "SOURCE": "host BILLING_DB in 'hosts'"}
+	if len(hosts) != 1 || len(hosts["BILLING_DB"]) != len(want) {
+		t.Fatalf("expected only BILLING_DB, got %v", hosts)
+	}
+	for k, v := range want {
+		if hosts["BILLING_DB"][k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, hosts["BILLING_DB"][k])
+		}
+	}
+
+	failed := make(map[string]string)
+	for _, rec := range rep.Records {
+		if rec.Status == report.Fail {
+			failed[rec.Name] = rec.Error
+		}
+	}
+	if !strings.Contains(failed["config:hosts:CACHE"], "CACHE_HOST (ADDRESS)") ||
+		!strings.Contains(failed["config:hosts:QUEUE"], "client must be one of") ||
+		!strings.Contains(failed["config:hosts:entry 4"], "no id") || len(failed) != 3 {
+		t.Errorf("unexpected config failures: %v", failed)
+	}
+}
+
+func TestMergeHosts(t *testing.T) {
+	hosts := map[string]map[string]string{
+		"BILLING_DB": {"ID": "BILLING_DB", "CLIENT": "POSTGRES10", "PASSWORD": "fromenv", "PORT": "6432"},
+		"CACHE":      {"ID": "CACHE", "CLIENT": "REDIS", "ADDRESS": "cache.domain.com"},
+	}
+	rep := report.Reset("test")
+	MergeHosts(hosts, map[string]map[string]string{
+		"BILLING_DB": {"ID": "BILLING_DB", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com", "PORT": "5432"},
+		"CACHE":      {"ID": "CACHE", "CLIENT": "MYSQL", "ADDRESS": "other.domain.com"},
+		"EVENTS":     {"ID": "EVENTS", "CLIENT": "KAFKA", "BROKERS": "k1:9092"},
+	})
+	rep.Finish()
+	db := hosts["BILLING_DB"]
+	if db["ADDRESS"] != "db.domain.com" || db["PORT"] != "6432" || db["PASSWORD"] != "fromenv" {
+		t.Errorf("expected the env var fields to win: %v", db)
+	}
+	if hosts["CACHE"]["CLIENT"] != "REDIS" || hosts["EVENTS"] == nil {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	if rep.Success || len(rep.Failures()) != 1 || rep.Failures()[0] != "config:hosts:CACHE" {
+		t.Errorf("expected the client mismatch to fail a config check: %v", rep.Failures())
+	}
+}
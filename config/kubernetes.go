@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Kubernetes doesn't have a metadata endpoint like ECS, so the pod has to be told about itself with the downward API,
+// either as env vars:
+//   env:
+//   - name: POD_NAME
+//     valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//   - name: POD_NAMESPACE
+//     valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//   - name: NODE_NAME
+//     valueFrom: {fieldRef: {fieldPath: spec.nodeName}}
+// or as files in a downwardAPI volume mounted at 'kubernetes_podinfo_path' (name, namespace, labels, annotations).
+// The namespace also comes from the service account mount when nothing else provides it. spec.nodeName can't go in a
+// downwardAPI volume, so the node name only comes from NODE_NAME.
+// https://kubernetes.io/docs/tasks/inject-data-application/downward-api-volume-expose-pod-information/
+
+const (
+	DefaultKubernetesPodinfoPath        string = "/etc/podinfo"
+	DefaultKubernetesServiceAccountPath string = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Return true if we're running in a Kubernetes pod. Every pod gets KUBERNETES_SERVICE_HOST, and the service account
+// mount catches the pods that have service links turned off
+func IsKubernetes(serviceAccountPath string) bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	_, err := os.Stat(serviceAccountPath)
+	return err == nil
+}
+
+// Gather pod metadata from downward API env vars, then the podinfo volume, then the service account mount. The node
+// name is only in NODE_NAME
+func GetKubernetesMetadata(podinfoPath, serviceAccountPath string) log.Fields {
+	fields := log.Fields{
+		"platform":      "kubernetes",
+		"pod_name":      firstNonEmpty(os.Getenv("POD_NAME"), readTrimmed(filepath.Join(podinfoPath, "name")), os.Getenv("HOSTNAME")),
+		"pod_namespace": firstNonEmpty(os.Getenv("POD_NAMESPACE"), readTrimmed(filepath.Join(podinfoPath, "namespace")), readTrimmed(filepath.Join(serviceAccountPath, "namespace"))),
+		"node_name":     os.Getenv("NODE_NAME"),
+	}
+	if labels, err := ReadDownwardAPIMap(filepath.Join(podinfoPath, "labels")); err == nil {
+		fields["labels"] = labels
+	}
+	if annotations, err := ReadDownwardAPIMap(filepath.Join(podinfoPath, "annotations")); err == nil {
+		fields["annotations"] = annotations
+	}
+	return fields
+}
+
+// Parse a downward API labels or annotations file. Each line looks like: app.kubernetes.io/name="billing"
+func ReadDownwardAPIMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	res := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("unable to parse %s: %q", path, line)
+		}
+		val, err := strconv.Unquote(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %q", path, line)
+		}
+		res[line[:i]] = val
+	}
+	return res, scanner.Err()
+}
+
+// Log the pod metadata. Do nothing when we're not in Kubernetes
+func LogKubernetesMetadata() {
+	saPath := viper.GetString("kubernetes_serviceaccount_path")
+	if !IsKubernetes(saPath) {
+		log.Debug("Not running in Kubernetes. Skipping pod metadata")
+		return
+	}
+	fields := GetKubernetesMetadata(viper.GetString("kubernetes_podinfo_path"), saPath)
+	log.WithFields(fields).Info("Kubernetes pod metadata")
+}
+
+func readTrimmed(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
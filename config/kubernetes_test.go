@@ -0,0 +1,118 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/spf13/viper"
+)
+
+// lay out a downward API volume and service account mount in a temp dir
+func fakePodMounts(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	podinfo := filepath.Join(dir, "podinfo")
+	sa := filepath.Join(dir, "serviceaccount")
+	_ = os.MkdirAll(podinfo, 0755)
+	_ = os.MkdirAll(sa, 0755)
+	files := map[string]string{
+		filepath.Join(podinfo, "name"):        "billing-7d4b9c-x2x9z\n",
+		filepath.Join(podinfo, "labels"):      "app.kubernetes.io/name=\"billing\"\npod-template-hash=\"7d4b9c\"\n",
+		filepath.Join(podinfo, "annotations"): "note=\"has \\\"quotes\\\"\"\n",
+		filepath.Join(sa, "namespace"):        "payments",
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return podinfo, sa, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestGetKubernetesMetadataFromFiles(t *testing.T) {
+	podinfo, sa, cleanup := fakePodMounts(t)
+	defer cleanup()
+	for _, k := range []string{"POD_NAME", "POD_NAMESPACE", "NODE_NAME"} {
+		_ = os.Unsetenv(k)
+	}
+
+	fields := GetKubernetesMetadata(podinfo, sa)
+	if fields["pod_name"] != "billing-7d4b9c-x2x9z" {
+		t.Errorf("pod_name = %v", fields["pod_name"])
+	}
+	// no namespace in podinfo, so it comes from the service account
+	if fields["pod_namespace"] != "payments" {
+		t.Errorf("pod_namespace = %v", fields["pod_namespace"])
+	}
+	labels := fields["labels"].(map[string]string)
+	if labels["app.kubernetes.io/name"] != "billing" || labels["pod-template-hash"] != "7d4b9c" {
+		t.Errorf("labels = %v", labels)
+	}
+	annotations := fields["annotations"].(map[string]string)
+	if annotations["note"] != `has "quotes"` {
+		t.Errorf("annotations = %v", annotations)
+	}
+}
+
+// downward API env vars win over the mounted files
+func TestGetKubernetesMetadataFromEnv(t *testing.T) {
+	podinfo, sa, cleanup := fakePodMounts(t)
+	defer cleanup()
+	_ = os.Setenv("POD_NAME", "from-env")
+	_ = os.Setenv("POD_NAMESPACE", "env-namespace")
+	_ = os.Setenv("NODE_NAME", "ip-10-0-0-1")
+	defer func() {
+		for _, k := range []string{"POD_NAME", "POD_NAMESPACE", "NODE_NAME"} {
+			_ = os.Unsetenv(k)
+		}
+	}()
+
+	fields := GetKubernetesMetadata(podinfo, sa)
+	if fields["pod_name"] != "from-env" || fields["pod_namespace"] != "env-namespace" || fields["node_name"] != "ip-10-0-0-1" {
+		t.Errorf("fields = %v", fields)
+	}
+}
+
+func TestReadDownwardAPIMapBadLine(t *testing.T) {
+	f, err := ioutil.TempFile("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, _ = f.WriteString("no equals sign here\n")
+	_ = f.Close()
+	if _, err := ReadDownwardAPIMap(f.Name()); err == nil {
+		t.Fail()
+	}
+}
+
+func TestLogKubernetesMetadata(t *testing.T) {
+	podinfo, sa, cleanup := fakePodMounts(t)
+	defer cleanup()
+	_ = os.Unsetenv("KUBERNETES_SERVICE_HOST")
+	viper.Set("kubernetes_podinfo_path", podinfo)
+	viper.Set("kubernetes_serviceaccount_path", sa)
+	defer func() {
+		viper.Set("kubernetes_podinfo_path", DefaultKubernetesPodinfoPath)
+		viper.Set("kubernetes_serviceaccount_path", DefaultKubernetesServiceAccountPath)
+	}()
+
+	hook := test.NewGlobal()
+	LogKubernetesMetadata()
+	if hook.LastEntry() == nil || hook.LastEntry().Message != "Kubernetes pod metadata" {
+		t.Fatal("expected pod metadata to be logged")
+	}
+
+	// without the service account mount or KUBERNETES_SERVICE_HOST we're not in a pod
+	viper.Set("kubernetes_serviceaccount_path", filepath.Join(sa, "missing"))
+	hook.Reset()
+	LogKubernetesMetadata()
+	if len(hook.AllEntries()) != 0 {
+		t.Errorf("unexpected log entry: %s", hook.LastEntry().Message)
+	}
+}
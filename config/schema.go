@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// Return map with hosts whose fields don't match their client's schema filtered out and a boolean that's only true
+// if every host matched. It runs right after the hosts are gathered, so a forgotten field is reported by its env var
+// name instead of as a confusing connection error. Validation doesn't connect to anything, so the hosts are validated
+// one after another in ID order to keep the log and the report in the same order every run. See Host Data Filter
+// Pipeline in settings.go
+func ValidateHosts(hosts map[string]map[string]string) (map[string]map[string]string, bool) {
+	success := true
+	res := make(map[string]map[string]string)
+	for _, id := range SortedHostIDs(hosts) {
+		if ValidateHost(hosts[id]) {
+			res[id] = hosts[id]
+		} else {
+			success = false
+		}
+	}
+	return res, success
+}
+
+// Fill in defaults for the host's missing optional fields and fail if a required one is still missing or a field has
+// a value the client doesn't accept, like an unknown SSLMODE. Fields the client doesn't know about get a warning
+// because they're probably typos
+func ValidateHost(hMap map[string]string) bool {
+	rec := hostRecord(report.Config, hMap, true, 0)
+	entry := log.WithFields(hostAudience(hMap))
+	checker, ok := clients.Lookup(hMap["CLIENT"])
+	if !ok {
+		rec.Status = report.Fail
+		rec.Error = fmt.Sprintf("no client checker registered for %s", hMap["CLIENT"])
+		entry.Error(fmt.Sprintf("No client checker registered for %s (host %s)", hMap["CLIENT"], hMap["ID"]))
+		report.Add(rec)
+		return false
+	}
+
+	fields, complete := clients.Fields(checker)
+	// every host map has ID and CLIENT, and SOURCE when it wasn't set with env vars
+	known := map[string]bool{"ID": true, "CLIENT": true, "SOURCE": true}
+	for _, name := range clients.CommonFields {
+		known[name] = true
+	}
+	var missing, invalid, fixes, names []string
+	for _, f := range fields {
+		known[f.Name] = true
+		names = append(names, f.Name)
+		if hMap[f.Name] != "" {
+			if err := f.Check(hMap[f.Name]); err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s %v", fieldSource(hMap, f.Name), err))
+				fixes = append(fixes, fmt.Sprintf("set %s to one of the values %s accepts", fieldSource(hMap, f.Name),
+					hMap["CLIENT"]))
+			}
+			continue
+		}
+		if f.Default != "" {
+			hMap[f.Name] = f.Default
+			entry.Info(fmt.Sprintf("%s is not set, host %s uses the default %s", fieldSource(hMap, f.Name),
+				hMap["ID"], f.Default))
+		} else if f.Required {
+			missing = append(missing, fieldSource(hMap, f.Name))
+		}
+	}
+	// without the TLS fields the TLS check can't inspect the client's hosts and would fail every one of them
+	if enabled, _ := clients.TLSEnabled(hMap); enabled && complete && !known["TLS"] {
+		invalid = append(invalid, fmt.Sprintf("%s is set, but %s hosts don't support TLS inspection",
+			fieldSource(hMap, "TLS"), hMap["CLIENT"]))
+		fixes = append(fixes, fmt.Sprintf("unset %s, %s hosts negotiate TLS in their own protocol, which the TLS "+
+			"check can't inspect", fieldSource(hMap, "TLS"), hMap["CLIENT"]))
+	}
+	var unknown []string
+	if complete {
+		for k := range hMap {
+			if !known[k] {
+				unknown = append(unknown, fieldSource(hMap, k))
+			}
+		}
+		sort.Strings(unknown)
+	}
+
+	var problems, remediations []string
+	if len(missing) > 0 {
+		verb := "is"
+		if len(missing) > 1 {
+			verb = "are"
+		}
+		problems = append(problems, fmt.Sprintf("%s %s not set", strings.Join(missing, ", "), verb))
+		fix := fmt.Sprintf("set %s", strings.Join(missing, ", "))
+		if hMap["SOURCE"] == "" {
+			fix += " in the container environment and list it in 'checked_environment_variables'"
+		}
+		remediations = append(remediations, fix)
+	}
+	if len(invalid) > 0 {
+		problems = append(problems, invalid...)
+		remediations = append(remediations, fixes...)
+	}
+	if len(unknown) > 0 {
+		problems = append(problems, fmt.Sprintf("%s hosts don't use %s", hMap["CLIENT"], strings.Join(unknown, ", ")))
+		remediations = append(remediations, fmt.Sprintf("check the spelling, %s hosts use %s and %s", hMap["CLIENT"],
+			strings.Join(names, ", "), strings.Join(clients.CommonFields, ", ")))
+	}
+	if len(problems) == 0 {
+		report.Add(rec)
+		return true
+	}
+	rec.Error, rec.Remediation = strings.Join(problems, "; "), strings.Join(remediations, "; ")
+	if len(missing) > 0 || len(invalid) > 0 {
+		rec.Status = report.Fail
+		entry.Error(fmt.Sprintf("host %s: %s", hMap["ID"], rec.Error))
+		report.Add(rec)
+		return false
+	}
+	rec.Status = report.Warn
+	entry.Warn(fmt.Sprintf("host %s: %s", hMap["ID"], rec.Error))
+	report.Add(rec)
+	return true
+}
+
+// Name where a host field is set, so messages point at what the user wrote: the env var, like
+// POSTGRES10_HOT_PICKLES_PORT, or the field in the host's SOURCE, like "port in DATABASE_URL". Env vars win when a
+// host is declared both ways, so a set env var is where the field came from
+func fieldSource(hMap map[string]string, field string) string {
+	envVar := strings.Join([]string{hMap["CLIENT"], hMap["ID"], field}, EVWordSeparator)
+	if hMap["SOURCE"] == "" {
+		return envVar
+	}
+	if _, ok := os.LookupEnv(envVar); ok {
+		return envVar
+	}
+	return fmt.Sprintf("%s in %s", strings.ToLower(field), hMap["SOURCE"])
+}
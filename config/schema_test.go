@@ -0,0 +1,118 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/natemarks/preflight/report"
+)
+
+func TestValidateHosts(t *testing.T) {
+	hosts := map[string]map[string]string{
+		"HOT_PICKLES": {"ID": "HOT_PICKLES", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com", "USERNAME": "jdoe"},
+		"COLD_PICKLES": {"ID": "COLD_PICKLES", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com",
+			"USERNAME": "jdoe", "SSLMDOE": "require", "DESCRIPTION": "cold storage"},
+		"NO_PICKLES":  {"ID": "NO_PICKLES", "CLIENT": "POSTGRES10"},
+		"ODD_PICKLES": {"ID": "ODD_PICKLES", "CLIENT": "ORACLE", "ADDRESS": "db.domain.com"},
+	}
+
+	rep := report.Reset("test")
+	res, ok := ValidateHosts(hosts)
+	rep.Finish()
+	if ok || len(res) != 2 || res["HOT_PICKLES"] == nil || res["COLD_PICKLES"] == nil {
+		t.Fatalf("expected only the hosts with every required field, got %v and %v", res, ok)
+	}
+	if res["HOT_PICKLES"]["PORT"] != "5432" {
+		t.Errorf("expected PORT to default to 5432, got %q", res["HOT_PICKLES"]["PORT"])
+	}
+
+	records := make(map[string]report.Record)
+	for _, rec := range rep.Records {
+		records[rec.Name] = rec
+	}
+	if rec := records["config:HOT_PICKLES"]; rec.Status != report.Pass {
+		t.Errorf("unexpected record for HOT_PICKLES: %+v", rec)
+	}
+	// typos are warnings, the host is still checked
+	if rec := records["config:COLD_PICKLES"]; rec.Status != report.Warn ||
+		!strings.Contains(rec.Error, "POSTGRES10_COLD_PICKLES_SSLMDOE") || strings.Contains(rec.Error, "DESCRIPTION") {
+		t.Errorf("unexpected record for COLD_PICKLES: %+v", rec)
+	}
+	if rec := records["config:NO_PICKLES"]; rec.Status != report.Fail ||
+		rec.Error != "POSTGRES10_NO_PICKLES_ADDRESS, POSTGRES10_NO_PICKLES_USERNAME are not set" {
+		t.Errorf("unexpected record for NO_PICKLES: %+v", rec)
+	}
+	if rec := records["config:ODD_PICKLES"]; rec.Status != report.Fail {
+		t.Errorf("expected an unknown client to fail: %+v", rec)
+	}
+}
+
+// a bad SSLMODE is a config failure, so the host never gets to the network checks
+func TestValidateHostsSSLMode(t *testing.T) {
+	hosts := map[string]map[string]string{
+		"SOUR_PICKLES": {"ID": "SOUR_PICKLES", "CLIENT": "POSTGRES10", "ADDRESS": "127.0.0.1", "PORT": "1",
+			"USERNAME": "jdoe", "SSLMODE": "ca-verify"},
+		"SWEET_PICKLES": {"ID": "SWEET_PICKLES", "CLIENT": "POSTGRES10", "ADDRESS": "127.0.0.1", "PORT": "1",
+			"USERNAME": "jdoe", "SSLMODE": "Verify-Full"},
+	}
+
+	rep := report.Reset("test")
+	res, ok := ValidateHosts(hosts)
+	CheckHosts(res)
+	rep.Finish()
+	if ok || len(res) != 1 || res["SWEET_PICKLES"] == nil {
+		t.Fatalf("expected only SWEET_PICKLES to pass, got %v and %v", res, ok)
+	}
+	for _, rec := range rep.Records {
+		if rec.HostID != "SOUR_PICKLES" {
+			continue
+		}
+		if rec.Category != report.Config {
+			t.Errorf("expected only a config record for SOUR_PICKLES, got %+v", rec)
+		}
+		if rec.Status != report.Fail || !strings.Contains(rec.Error,
+			`POSTGRES10_SOUR_PICKLES_SSLMODE has to be one of disable, allow, prefer, require, verify-ca, verify-full, not "ca-verify"`) {
+			t.Errorf("unexpected record for SOUR_PICKLES: %+v", rec)
+		}
+	}
+}
+
+// MySQL negotiates TLS inside its own protocol, so the TLS check can't inspect it
+func TestValidateHostTLSUnsupported(t *testing.T) {
+	rep := report.Reset("test")
+	ok := ValidateHost(map[string]string{"ID": "ORDERS", "CLIENT": "MYSQL", "ADDRESS": "db.domain.com",
+		"USERNAME": "jdoe", "TLS": "true"})
+	rep.Finish()
+	if ok || len(rep.Records) != 1 || !strings.Contains(rep.Records[0].Error,
+		"MYSQL_ORDERS_TLS is set, but MYSQL hosts don't support TLS inspection") {
+		t.Errorf("expected TLS on a MYSQL host to fail validation, got %v and %+v", ok, rep.Records)
+	}
+	rep = report.Reset("test")
+	ok = ValidateHost(map[string]string{"ID": "HOT_PICKLES", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com",
+		"USERNAME": "jdoe", "TLS": "true"})
+	rep.Finish()
+	if !ok || rep.Records[0].Status != report.Pass {
+		t.Errorf("expected TLS on a POSTGRES10 host to pass validation, got %+v", rep.Records)
+	}
+}
+
+// hosts from the 'hosts' section and connection URLs are reported by where they were declared, not by env vars nobody
+// wrote
+func TestValidateHostSource(t *testing.T) {
+	rep := report.Reset("test")
+	ValidateHost(map[string]string{"ID": "BILLING_DB", "CLIENT": "POSTGRES10", "USERNAME": "jdoe",
+		"SOURCE": "host BILLING_DB in 'hosts'"})
+	ValidateHost(map[string]string{"ID": "DATABASE", "CLIENT": "POSTGRES10", "ADDRESS": "db.domain.com",
+		"USERNAME": "jdoe", "SSLMODE": "sometimes", "SOURCE": "DATABASE_URL"})
+	rep.Finish()
+	if len(rep.Records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", rep.Records)
+	}
+	if rec := rep.Records[0]; rec.Error != "address in host BILLING_DB in 'hosts' is not set" ||
+		strings.Contains(rec.Remediation, "checked_environment_variables") {
+		t.Errorf("unexpected record for BILLING_DB: %+v", rec)
+	}
+	if rec := rep.Records[1]; !strings.HasPrefix(rec.Error, "sslmode in DATABASE_URL has to be one of") {
+		t.Errorf("unexpected record for DATABASE: %+v", rec)
+	}
+}
@@ -1,14 +1,17 @@
 package config
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/natemarks/preflight/utility"
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -35,19 +38,16 @@ import (
 // list.
 
 const (
-	DefaultVerbose      bool   = false
-	DefaultOrganization string = "MyCompanyName"
-	DefaultTeam         string = "DevOps"
-	EVWordSeparator     string = "_"
-	ConnTimeoutMS       int64  = 3000 // default connection timeout in milliseconds
+	DefaultVerbose       bool   = false
+	DefaultOrganization  string = "MyCompanyName"
+	DefaultTeam          string = "DevOps"
+	DefaultParallelism   int    = 8 // default number of hosts checked at the same time
+	DefaultListenAddress string = ":8080"
+	DefaultCheckInterval        = 30 * time.Second // time between check runs in serve mode
+	EVWordSeparator      string = "_"
+	ConnTimeoutMS        int64  = 3000 // default connection timeout in milliseconds
 )
 
-//  Supported clients is used to determine environment variables that contain host attributes AND
-// to match a map host attributes to a client access testing function that can handle them
-var SupportedClients = []string{
-	"POSTGRES10",
-}
-
 // Get all of the config settings from file, environment, flag, etc and return a config object
 func GetSettings() {
 	DefineViperDefaults()
@@ -66,6 +66,14 @@ func DefineViperDefaults() {
 	viper.SetDefault("verbose", DefaultVerbose)
 	viper.SetDefault("organization", DefaultOrganization)
 	viper.SetDefault("team", DefaultTeam)
+	viper.SetDefault("parallelism", DefaultParallelism)
+	viper.SetDefault("state_file", DefaultStateFile)
+	viper.SetDefault("listen_address", DefaultListenAddress)
+	viper.SetDefault("check_interval", DefaultCheckInterval)
+	viper.SetDefault("stable_after", DefaultStableAfter)
+	viper.SetDefault("tls_expiry_warning", DefaultTLSExpiryWarning)
+	viper.SetDefault("kubernetes_podinfo_path", DefaultKubernetesPodinfoPath)
+	viper.SetDefault("kubernetes_serviceaccount_path", DefaultKubernetesServiceAccountPath)
 }
 
 func DefineViperConfigFile() {
@@ -97,45 +105,95 @@ func GetHash(s string) string {
 
 // Return a verified map of environment variables and values
 func CheckVars(ll []string) (map[string]string, bool) {
+	return CheckVarSpecs(VarSpecs(ll))
+}
+
+// Return a map of the environment variables that are set and pass their rules, and true if all of them did
+func CheckVarSpecs(specs []VarSpec) (map[string]string, bool) {
 	success := true
 	res := make(map[string]string)
-	if len(ll) == 0 {
+	if len(specs) == 0 {
 		log.Error("no environment variables to check")
 		success = false
+		report.Add(report.Record{
+			Name:        "config:checked_environment_variables",
+			Category:    report.Config,
+			Status:      report.Fail,
+			Error:       "no environment variables to check",
+			Remediation: "list the environment variables the service needs in 'checked_environment_variables'",
+		})
 	}
-	for _, key := range ll {
-		val, ok := IsSet(key)
-		if ok {
-			res[key] = val
-
-		} else {
+	for _, spec := range specs {
+		val, ok := CheckVar(spec)
+		if !ok {
 			success = false
 			continue
 		}
+		// an optional variable without a value or a default has nothing to add
+		if val != "" {
+			res[spec.Name] = val
+		}
 	}
-	log.Info(fmt.Sprintf("Checked %d environment variables.  Finished", len(ll)))
+	log.Info(fmt.Sprintf("Checked %d environment variables.  Finished", len(specs)))
 	return res, success
 }
 
 // Return true if the environment variable is set to a non-empty value
 func IsSet(key string) (string, bool) {
-	success := true
+	return CheckVar(VarSpec{Name: key})
+}
+
+// Return true if the environment variable is set to a non-empty value that passes the spec's rules. A warn or info
+// variable that's unset, empty or invalid is reported at its severity and returns true with the spec's default, if it
+// has one
+func CheckVar(spec VarSpec) (string, bool) {
+	key := spec.Name
+	rec := report.Record{Name: "env:" + key, Category: report.EnvVar, Status: report.Pass}
 	val, ok := os.LookupEnv(key)
-	if ok {
-		if val == "" {
-			errorMsg := fmt.Sprintf("environment variable set, but empty: %s", val)
-			log.Error(errorMsg)
-			success = false
+	var errorMsg, missing string
+	switch {
+	case !ok:
+		errorMsg, missing = fmt.Sprintf("environment variable key does not exist: %s", key), "unset"
+		rec.Error, rec.Remediation = "not set", fmt.Sprintf("set %s in the container environment", key)
+	case val == "":
+		errorMsg, missing = fmt.Sprintf("environment variable set, but empty: %s", key), "set, but empty"
+		rec.Error, rec.Remediation = "set, but empty", fmt.Sprintf("give %s a value in the container environment", key)
+	default:
+		if err := spec.Validate(val); err != nil {
+			errorMsg = fmt.Sprintf("environment variable is not valid: %v", err)
+			rec.Error = err.Error()
+			rec.Remediation = fmt.Sprintf("set %s to a value that passes its rules in 'checked_environment_variables'", key)
+		}
+	}
+	entry := log.WithFields(AudienceFields(key))
+	switch {
+	case errorMsg == "":
+		hash := GetHash(val)
+		entry.Info(fmt.Sprintf("environment variable found: %s = %s (sha256)", key, hash))
+		report.Add(rec)
+		return val, true
+	case spec.Severity == SeverityWarn || spec.Severity == SeverityInfo:
+		if missing != "" {
+			rec.Error = fmt.Sprintf("%s %s", key, missing)
+		}
+		val = spec.Default
+		if spec.Default != "" {
+			rec.Error += fmt.Sprintf(", service will use default '%s'", spec.Default)
+		}
+		if spec.Severity == SeverityWarn {
+			rec.Status = report.Warn
+			entry.Warn(rec.Error)
 		} else {
-			hash := GetHash(val)
-			log.Info(fmt.Sprintf("environment variable found: %s = %s (sha256)", key, hash))
+			rec.Status, rec.Remediation = report.Info, ""
+			entry.Info(rec.Error)
 		}
-	} else {
-		errorMsg := fmt.Sprintf("environment variable key does not exist: %s", key)
-		log.Error(errorMsg)
-		success = false
+		report.Add(rec)
+		return val, true
 	}
-	return val, success
+	entry.Error(errorMsg)
+	rec.Status = report.Fail
+	report.Add(rec)
+	return val, false
 }
 
 // MAIN calls GetHosts(varMap) and saves the returned map to hostMap
@@ -202,8 +260,8 @@ func GetHosts(envVars map[string]string) map[string]map[string]string {
 //	"username": "jdoe",
 // }
 // The first part is always the client. The last part is the field. All the midde parts are the identity
-// If the first part matches a reserved string that represents a client type we can test, the environment variable is assumed to hold
-// host connection information
+// If the first part matches the prefix of a registered client checker (see clients.Register), the environment variable
+// is assumed to hold host connection information
 func GetHostFromEV(key string, value string) (map[string]string, bool) {
 	success := true
 	words := strings.Split(key, EVWordSeparator)
@@ -218,7 +276,7 @@ func GetHostFromEV(key string, value string) (map[string]string, bool) {
 	// strip the first slice entry out for the client and keep the remaining list in theRest
 	client, theRest := words[0], words[1:]
 
-	if !utility.Contains(SupportedClients, client) {
+	if _, ok := clients.Lookup(client); !ok {
 		errMsg := fmt.Sprintf("prefix doesn't match a supported client: %s", client)
 		success = false
 		log.Debug(errMsg)
@@ -247,27 +305,79 @@ func UpdateMap(myMap map[string]string, addMap map[string]string) {
 // Return map with failed checked filtered out and a boolean that's only true if everything succeeded
 // See Host Data Filter Pipeline at the top for more information
 func GetReachableHosts(hosts map[string]map[string]string) (map[string]map[string]string, bool) {
-	success := true
+	return filterHosts(hosts, IsReachable)
+}
 
-	res := make(map[string]map[string]string)
+// Resolve each of the host's endpoints and make sure something accepts tcp connections on them. Usually that's just
+// ADDRESS:PORT, but some clients describe their hosts differently (see clients.Endpointer). The resolved IPs are saved
+// as RESOLVED_ADDRESS. ADDRESS keeps the name because client checks need it to verify TLS certificates
+func IsReachable(hMap map[string]string) bool {
+	start := time.Now()
+	endpoints, err := clients.Endpoints(hMap)
+	if err != nil {
+		rec := hostRecord(report.Resolve, hMap, false, time.Since(start))
+		rec.Error = err.Error()
+		rec.Remediation = fmt.Sprintf("check the %s_%s_<FIELD> environment variables", hMap["CLIENT"], hMap["ID"])
+		log.WithFields(hostAudience(hMap)).Error(fmt.Sprintf("Unable to get the endpoints for host %s: %v", hMap["ID"], err))
+		report.Add(rec)
+		skipped := hostRecord(report.TCP, hMap, false, 0)
+		skipped.Status = report.Skip
+		report.Add(skipped)
+		return false
+	}
 
-	for _, hMap := range hosts {
-		var ok bool
-		hMap["ADDRESS"], ok = ResolveHostName(hMap["ADDRESS"])
+	var addresses, unresolved []string
+	for _, ep := range endpoints {
+		address, ok := ResolveHostName(ep.Address)
 		if !ok {
-			success = false
-			continue
-		} else {
-			ok := CanConnect(hMap["ADDRESS"], hMap["PORT"], ConnTimeoutMS)
-			if ok {
-				res[hMap["ID"]] = hMap
-			} else {
-				success = false
-			}
+			unresolved = append(unresolved, ep.Address)
+		}
+		addresses = append(addresses, address)
+	}
+	rec := hostRecord(report.Resolve, hMap, len(unresolved) == 0, time.Since(start))
+	if len(unresolved) > 0 {
+		rec.Error = fmt.Sprintf("unable to resolve %s", strings.Join(unresolved, ", "))
+		rec.Remediation = fmt.Sprintf("check the ADDRESS for host %s and that DNS in the container can resolve it", hMap["ID"])
+		report.Add(rec)
+		skipped := hostRecord(report.TCP, hMap, false, 0)
+		skipped.Status = report.Skip
+		report.Add(skipped)
+		return false
+	}
+	report.Add(rec)
+	hMap["RESOLVED_ADDRESS"] = strings.Join(addresses, ",")
+
+	start = time.Now()
+	var unreachable []string
+	for i, ep := range endpoints {
+		if !CanConnect(addresses[i], ep.Port, ConnTimeoutMS) {
+			unreachable = append(unreachable, net.JoinHostPort(addresses[i], ep.Port))
 		}
+	}
+	rec = hostRecord(report.TCP, hMap, len(unreachable) == 0, time.Since(start))
+	if len(unreachable) > 0 {
+		rec.Error = fmt.Sprintf("unable to connect to %s", strings.Join(unreachable, ", "))
+		rec.Remediation = fmt.Sprintf("check the PORT for host %s, that the service is listening and that security "+
+			"groups or network policies allow the connection", hMap["ID"])
+	}
+	report.Add(rec)
+	return len(unreachable) == 0
+}
 
+// Start a report record for a check against a host
+func hostRecord(category report.Category, hMap map[string]string, ok bool, duration time.Duration) report.Record {
+	rec := report.Record{
+		Name:     fmt.Sprintf("%s:%s", category, hMap["ID"]),
+		Category: category,
+		HostID:   hMap["ID"],
+		Client:   hMap["CLIENT"],
+		Status:   report.Pass,
+		Duration: duration,
 	}
-	return res, success
+	if !ok {
+		rec.Status = report.Fail
+	}
+	return rec
 }
 
 // given either a cidr or a host name, return the IP  address or error out
@@ -314,6 +424,78 @@ func CanConnect(address, port string, timeout int64) bool {
 	return success
 }
 
+// Return map with hosts that failed their client access check filtered out and a boolean that's only true if every
+// check passed. See Host Data Filter Pipeline at the top for more information
+func VerifyClientAccess(hosts map[string]map[string]string) (map[string]map[string]string, bool) {
+	return filterHosts(hosts, VerifyHostAccess)
+}
+
+// Run the registered client checker for a single host map
+func VerifyHostAccess(hMap map[string]string) bool {
+	rec := hostRecord(report.Client, hMap, false, 0)
+	checker, ok := clients.Lookup(hMap["CLIENT"])
+	if !ok {
+		rec.Error = fmt.Sprintf("no client checker registered for %s", hMap["CLIENT"])
+		log.WithFields(hostAudience(hMap)).Error(
+			fmt.Sprintf("No client checker registered for %s (host %s)", hMap["CLIENT"], hMap["ID"]))
+		report.Add(rec)
+		return false
+	}
+	missing := clients.MissingFields(checker, hMap)
+	if len(missing) > 0 {
+		rec.Error = fmt.Sprintf("missing required fields: %s", strings.Join(missing, ", "))
+		rec.Remediation = fmt.Sprintf("set the missing %s_%s_<FIELD> environment variables", hMap["CLIENT"], hMap["ID"])
+		log.WithFields(hostAudience(hMap)).Error(fmt.Sprintf("%s host %s is missing required fields: %s",
+			hMap["CLIENT"], hMap["ID"], strings.Join(missing, ", ")))
+		report.Add(rec)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ConnTimeoutMS)*time.Millisecond)
+	defer cancel()
+	result := checker.Run(ctx, hMap)
+	rec.Duration = result.Duration
+	if !result.OK {
+		rec.Error = result.Message
+		rec.Remediation = clientRemediation(result.Err, hMap)
+		log.WithFields(hostAudience(hMap)).Error(result.String())
+		report.Add(rec)
+		return false
+	}
+	rec.Status = report.Pass
+	log.WithFields(hostAudience(hMap)).Info(result.String())
+	report.Add(rec)
+	return true
+}
+
+// Return the audience fields for a host. An override for the host ID beats one for its client type
+func hostAudience(hMap map[string]string) log.Fields {
+	return AudienceFields(hMap["ID"], hMap["CLIENT"])
+}
+
+// Suggest a fix for a failed client check based on what kind of failure it was
+func clientRemediation(err error, hMap map[string]string) string {
+	switch {
+	case errors.Is(err, clients.ErrAuth):
+		return fmt.Sprintf("check the credentials for host %s", hMap["ID"])
+	case errors.Is(err, clients.ErrRejected):
+		return fmt.Sprintf("check that the account for host %s has access to what the service needs", hMap["ID"])
+	case errors.Is(err, clients.ErrTLS) && hMap["CLIENTCERT"] != "":
+		return fmt.Sprintf("check the certificates for host %s: CLIENTCERT and CLIENTKEY have to be a matching, "+
+			"unexpired pair signed by a CA the server trusts, and the server certificate has to be signed by a CA in "+
+			"CAFILE or the system roots and match SERVERNAME or the address", hMap["ID"])
+	case errors.Is(err, clients.ErrTLS):
+		return fmt.Sprintf("check the certificate for host %s: it has to be signed by a CA in CAFILE or the system roots, "+
+			"match SERVERNAME or the address, and not be expired", hMap["ID"])
+	case errors.Is(err, clients.ErrNetwork):
+		return fmt.Sprintf("check that host %s is up and reachable from the container", hMap["ID"])
+	default:
+		return fmt.Sprintf("check that host %s is a %s server", hMap["ID"], hMap["CLIENT"])
+	}
+}
+
+// Log whatever the container platform tells us about the image and task we're running in
 func LogContainerMetadata() {
-	///if it's AWS log the image name/version/whatever else we want from the localhost metadata json curl
+	LogECSMetadata()
+	LogKubernetesMetadata()
 }
@@ -2,10 +2,14 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
 	log "github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/spf13/viper"
@@ -76,8 +80,8 @@ func TestIsSetEmpty(t *testing.T) {
 	_ = os.Setenv("EMPTY_VAR", "")
 	_, ok := IsSet("EMPTY_VAR")
 
-	// should log the empty variable message
-	if !strings.Contains(hook.LastEntry().Message, "environment variable set, but empty:") {
+	// should log the empty variable message with the variable's name
+	if !strings.Contains(hook.LastEntry().Message, "environment variable set, but empty: EMPTY_VAR") {
 		t.Fail()
 	}
 	// should return an error
@@ -104,6 +108,21 @@ func TestIsSetSuccess(t *testing.T) {
 	}
 }
 
+// passes are routed to the variable's audience like failures
+func TestIsSetSuccessAudience(t *testing.T) {
+	hook := test.NewGlobal()
+	viper.Set("audiences", map[string]string{"VALID_VAR": "Payments"})
+	defer viper.Set("audiences", map[string]string{})
+
+	_ = os.Setenv("VALID_VAR", "VALID_VALUE")
+	if _, ok := IsSet("VALID_VAR"); !ok {
+		t.Fatal("expected VALID_VAR to be set")
+	}
+	if e := hook.LastEntry(); e.Data["team"] != "Payments" {
+		t.Errorf("expected the Payments audience on %q, got %v", e.Message, e.Data)
+	}
+}
+
 func TestCheckVarsEmpty(t *testing.T) {
 	hook := test.NewGlobal()
 	v := []string{}
@@ -298,3 +317,116 @@ func TestGetHosts(t *testing.T) {
 	}
 
 }
+
+// Only environment variables that start with a registered client prefix hold host data
+func TestGetHostFromEVUnregisteredClient(t *testing.T) {
+	if _, ok := GetHostFromEV("MONGO2_SOME_DB_ADDRESS", "10.0.0.1"); ok {
+		t.Fail()
+	}
+	hMap, ok := GetHostFromEV("POSTGRES10_SOME_DB_ADDRESS", "10.0.0.1")
+	if !ok {
+		t.Fail()
+	}
+	if hMap["ID"] != "SOME_DB" || hMap["CLIENT"] != "POSTGRES10" || hMap["ADDRESS"] != "10.0.0.1" {
+		t.Fail()
+	}
+}
+
+func init() {
+	clients.Register(&clients.TCPChecker{ClientName: "tcp test", ClientPrefix: "TCPTEST"})
+}
+
+// One host listens locally, one has no listener and one has no checker registered for its client
+func TestVerifyClientAccess(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = closed.Close()
+
+	var testMap = map[string]map[string]string{
+		"listening": {
+			"ID":      "listening",
+			"CLIENT":  "TCPTEST",
+			"ADDRESS": "127.0.0.1",
+			"PORT":    strconv.Itoa(ln.Addr().(*net.TCPAddr).Port),
+		},
+		"closed": {
+			"ID":      "closed",
+			"CLIENT":  "TCPTEST",
+			"ADDRESS": "127.0.0.1",
+			"PORT":    strconv.Itoa(closed.Addr().(*net.TCPAddr).Port),
+		},
+		"unknown": {
+			"ID":      "unknown",
+			"CLIENT":  "NOSUCHCLIENT",
+			"ADDRESS": "127.0.0.1",
+			"PORT":    "1",
+		},
+	}
+
+	res, ok := VerifyClientAccess(testMap)
+	if ok {
+		t.Fail()
+	}
+	if len(res) != 1 {
+		t.Fail()
+	}
+	if _, found := res["listening"]; !found {
+		t.Fail()
+	}
+}
+
+// Each env var check adds a record to the report
+func TestIsSetReport(t *testing.T) {
+	rep := report.Reset("test")
+	_ = os.Setenv("VALID_VAR", "VALID_VALUE")
+	_ = os.Unsetenv("NONEXISTENT_VAR")
+	IsSet("VALID_VAR")
+	IsSet("NONEXISTENT_VAR")
+	rep.Finish()
+
+	if len(rep.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(rep.Records))
+	}
+	if rep.Records[0].Name != "env:NONEXISTENT_VAR" || rep.Records[0].Status != report.Fail || rep.Records[0].Remediation == "" {
+		t.Errorf("unexpected record: %+v", rep.Records[0])
+	}
+	if rep.Records[1].Name != "env:VALID_VAR" || rep.Records[1].Status != report.Pass {
+		t.Errorf("unexpected record: %+v", rep.Records[1])
+	}
+}
+
+// HTTP hosts have a URL instead of ADDRESS and PORT. The resolve and tcp checks use the host and port from it
+func TestIsReachableURL(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	rep := report.Reset("test")
+	api := map[string]string{"ID": "BILLING_API", "CLIENT": "HTTP", "URL": fmt.Sprintf("http://%s/health", ln.Addr())}
+	if !IsReachable(api) || api["RESOLVED_ADDRESS"] != "127.0.0.1" {
+		t.Errorf("expected the URL's host to be reachable: %+v", api)
+	}
+	bad := map[string]string{"ID": "BAD_API", "CLIENT": "HTTP", "URL": "billing.domain.com/health"}
+	if IsReachable(bad) {
+		t.Error("expected a URL without a scheme to fail")
+	}
+	rep.Finish()
+
+	statuses := make(map[string]report.Status)
+	for _, rec := range rep.Records {
+		statuses[rec.Name] = rec.Status
+	}
+	if statuses["resolve:BILLING_API"] != report.Pass || statuses["tcp:BILLING_API"] != report.Pass ||
+		statuses["resolve:BAD_API"] != report.Fail || statuses["tcp:BAD_API"] != report.Skip {
+		t.Errorf("unexpected records: %+v", rep.Records)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// preflight writes a small state file when it runs so 'preflight finalize' can tell how long the service ran once it
+// exits. The file lives next to the liveness flag file in /tmp by default, which is fine because both only need to
+// last as long as the container
+
+const (
+	DefaultStateFile   string        = "/tmp/preflight_state.json"
+	DefaultStableAfter time.Duration = 5 * time.Minute // a service that runs at least this long isn't flapping
+)
+
+// RunState is what the preflight run leaves behind for finalize
+type RunState struct {
+	Version  string    `json:"version"`
+	Started  time.Time `json:"started"`
+	Hostname string    `json:"hostname"`
+}
+
+// Return the configured state file path
+func StateFile() string {
+	return viper.GetString("state_file")
+}
+
+// Write the run state to path as json
+func WriteState(path string, state RunState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Read the run state a previous preflight run wrote to path
+func ReadState(path string) (RunState, error) {
+	var state RunState
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("unable to parse state file %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// Return a RunState for a preflight run starting now
+func NewRunState(version string) RunState {
+	hostname, _ := os.Hostname()
+	return RunState{
+		Version:  version,
+		Started:  time.Now(),
+		Hostname: hostname,
+	}
+}
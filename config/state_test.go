@@ -0,0 +1,52 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "preflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "state.json")
+
+	state := NewRunState("v9.9.9")
+	if err := WriteState(path, state); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "v9.9.9" || got.Hostname != state.Hostname || !got.Started.Equal(state.Started) {
+		t.Errorf("ReadState() = %+v; want %+v", got, state)
+	}
+	if time.Since(got.Started) > time.Minute {
+		t.Errorf("unexpected start time %s", got.Started)
+	}
+}
+
+func TestReadStateMissing(t *testing.T) {
+	if _, err := ReadState("/nonexistent/preflight_state.json"); err == nil {
+		t.Fail()
+	}
+}
+
+func TestReadStateGarbage(t *testing.T) {
+	f, err := ioutil.TempFile("", "preflight_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, _ = f.WriteString("not json")
+	_ = f.Close()
+	if _, err := ReadState(f.Name()); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// default for 'tls_expiry_warning': certificates that expire sooner than this get a warning
+const DefaultTLSExpiryWarning = 14 * 24 * time.Hour
+
+// For hosts with TLS=true, run a TLS handshake with each of the host's endpoints. The handshake verifies the chain
+// against CAFILE (or the system roots) and the certificate name against SERVERNAME (or the address), so an untrusted,
+// misnamed or expired certificate fails. One that expires within 'tls_expiry_warning' passes with a warning. Hosts
+// without TLS pass without a record
+func InspectHostTLS(hMap map[string]string) bool {
+	enabled, err := clients.TLSEnabled(hMap)
+	if err == nil && !enabled {
+		return true
+	}
+	start := time.Now()
+	var problems []string
+	var firstErr error
+	var soonest *x509.Certificate
+	if err != nil {
+		problems, firstErr = append(problems, err.Error()), err
+	} else if endpoints, err := clients.Endpoints(hMap); err != nil {
+		problems, firstErr = append(problems, err.Error()), err
+	} else {
+		for _, ep := range endpoints {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ConnTimeoutMS)*time.Millisecond)
+			cert, err := clients.InspectTLS(ctx, hMap, ep)
+			cancel()
+			if err != nil {
+				problems = append(problems, err.Error())
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			if soonest == nil || cert.NotAfter.Before(soonest.NotAfter) {
+				soonest = cert
+			}
+		}
+		if len(endpoints) == 0 {
+			firstErr = fmt.Errorf("host %s has no endpoints to inspect", hMap["ID"])
+			problems = append(problems, firstErr.Error())
+		}
+	}
+
+	rec := hostRecord(report.TLS, hMap, len(problems) == 0, time.Since(start))
+	entry := log.WithFields(hostAudience(hMap))
+	if len(problems) > 0 {
+		rec.Error = strings.Join(problems, "; ")
+		rec.Remediation = clientRemediation(firstErr, hMap)
+		entry.Error(fmt.Sprintf("TLS check failed for host %s: %s", hMap["ID"], rec.Error))
+		report.Add(rec)
+		return false
+	}
+
+	remaining := time.Until(soonest.NotAfter)
+	expires := fmt.Sprintf("certificate %q expires in %s on %s", soonest.Subject.CommonName,
+		remaining.Round(time.Hour), soonest.NotAfter.UTC().Format(time.RFC3339))
+	if remaining < viper.GetDuration("tls_expiry_warning") {
+		rec.Status = report.Warn
+		rec.Error = expires
+		rec.Remediation = fmt.Sprintf("renew the certificate for host %s before it expires", hMap["ID"])
+		entry.Warn(fmt.Sprintf("TLS check for host %s: %s", hMap["ID"], expires))
+	} else {
+		entry.Info(fmt.Sprintf("TLS check passed for host %s: %s", hMap["ID"], expires))
+	}
+	report.Add(rec)
+	return true
+}
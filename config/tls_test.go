@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/natemarks/preflight/clients"
+	"github.com/natemarks/preflight/report"
+	"github.com/spf13/viper"
+)
+
+// tlsServer accepts TLS connections with a self-signed certificate for localhost that expires at notAfter. caFile
+// trusts it
+type tlsServer struct {
+	ln     net.Listener
+	caFile string
+}
+
+func startTLSServer(t *testing.T, notAfter time.Time) *tlsServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-48 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "preflight-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	_ = f.Close()
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	return &tlsServer{ln: ln, caFile: f.Name()}
+}
+
+func (s *tlsServer) Close() {
+	_ = s.ln.Close()
+	_ = os.Remove(s.caFile)
+}
+
+func (s *tlsServer) host(fields map[string]string) map[string]string {
+	_, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	host := map[string]string{"ID": "BILLING", "CLIENT": "TCPTEST", "ADDRESS": "localhost", "PORT": port,
+		"TLS": "true", "CAFILE": s.caFile}
+	for k, v := range fields {
+		host[k] = v
+	}
+	return host
+}
+
+func TestInspectHostTLS(t *testing.T) {
+	viper.Set("tls_expiry_warning", "336h")
+	valid := startTLSServer(t, time.Now().Add(365*24*time.Hour))
+	defer valid.Close()
+	expiring := startTLSServer(t, time.Now().Add(48*time.Hour))
+	defer expiring.Close()
+	expired := startTLSServer(t, time.Now().Add(-time.Hour))
+	defer expired.Close()
+
+	cases := []struct {
+		name   string
+		host   map[string]string
+		ok     bool
+		status report.Status
+		errMsg string
+	}{
+		{"valid", valid.host(nil), true, report.Pass, ""},
+		{"expiring soon", expiring.host(nil), true, report.Warn, "expires in"},
+		{"expired", expired.host(nil), false, report.Fail, "expired"},
+		{"untrusted", valid.host(map[string]string{"CAFILE": ""}), false, report.Fail, "unknown authority"},
+		{"wrong name", valid.host(map[string]string{"SERVERNAME": "billing.domain.com"}), false, report.Fail, "billing.domain.com"},
+		{"bad TLS field", valid.host(map[string]string{"TLS": "maybe"}), false, report.Fail, "TLS must be"},
+	}
+	for _, c := range cases {
+		rep := report.Reset("test")
+		ok := InspectHostTLS(c.host)
+		rep.Finish()
+		if ok != c.ok || len(rep.Records) != 1 {
+			t.Errorf("%s: expected %v with one record, got %v with %+v", c.name, c.ok, ok, rep.Records)
+			continue
+		}
+		rec := rep.Records[0]
+		if rec.Name != "tls:BILLING" || rec.Status != c.status || !strings.Contains(rec.Error, c.errMsg) {
+			t.Errorf("%s: unexpected record %+v", c.name, rec)
+		}
+		if c.status != report.Pass && rec.Remediation == "" {
+			t.Errorf("%s: expected a remediation", c.name)
+		}
+	}
+}
+
+// hosts that don't ask for TLS aren't inspected
+func TestInspectHostTLSDisabled(t *testing.T) {
+	rep := report.Reset("test")
+	if !InspectHostTLS(map[string]string{"ID": "BILLING", "CLIENT": "TCPTEST", "ADDRESS": "localhost", "PORT": "1"}) {
+		t.Error("expected a host without TLS to pass")
+	}
+	rep.Finish()
+	if len(rep.Records) != 0 {
+		t.Errorf("expected no records, got %+v", rep.Records)
+	}
+}
+
+// noEndpoints is a checker whose hosts have nothing to connect to
+type noEndpoints struct{}
+
+func (c *noEndpoints) Name() string             { return "no endpoints" }
+func (c *noEndpoints) Prefix() string           { return "NOENDPOINTS" }
+func (c *noEndpoints) RequiredFields() []string { return nil }
+func (c *noEndpoints) Endpoints(host map[string]string) ([]clients.Endpoint, error) {
+	return nil, nil
+}
+func (c *noEndpoints) Run(ctx context.Context, host map[string]string) clients.Result {
+	return clients.NewResult(host).Pass("nothing to check")
+}
+
+func init() {
+	clients.Register(&noEndpoints{})
+}
+
+func TestInspectHostTLSNoEndpoints(t *testing.T) {
+	rep := report.Reset("test")
+	ok := InspectHostTLS(map[string]string{"ID": "NOWHERE", "CLIENT": "NOENDPOINTS", "TLS": "true"})
+	rep.Finish()
+	if ok || len(rep.Records) != 1 || !strings.Contains(rep.Records[0].Error, "no endpoints") {
+		t.Errorf("expected a host without endpoints to fail, got %v and %+v", ok, rep.Records)
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// Services often take a whole connection URL in one variable, like DATABASE_URL=postgres://user:pass@db:5432/app or
+// REDIS_URL=rediss://cache:6380/2. Checked variables named *_URL or *_DSN with one of these schemes become hosts with
+// the rest of the name as the ID, so DATABASE_URL is host DATABASE. Other schemes, like https, are left alone
+type urlScheme struct {
+	client  string
+	port    string
+	tls     bool
+	options map[string]string // query options the client understands and the host fields they set
+	// query options that ask for TLS the client's check can't do. The check runs over plaintext, so they get a warning
+	// instead of being ignored quietly
+	tlsOptions []string
+}
+
+// the libpq options the Postgres checker reads
+var pgURLOptions = map[string]string{"sslmode": "SSLMODE", "sslrootcert": "SSLROOTCERT", "sslcert": "SSLCERT",
+	"sslkey": "SSLKEY"}
+
+// the ways MySQL drivers ask for TLS. MYSQL hosts don't support TLS, see ValidateHost
+var mysqlTLSOptions = []string{"tls", "ssl-mode", "sslmode"}
+
+// values of the TLS options that turn TLS off
+var tlsOffValues = map[string]bool{"false": true, "disabled": true, "disable": true}
+
+var urlSchemes = map[string]urlScheme{
+	"postgres":    {client: "POSTGRES10", port: "5432", options: pgURLOptions},
+	"postgresql":  {client: "POSTGRES10", port: "5432", options: pgURLOptions},
+	"mysql":       {client: "MYSQL", port: "3306", tlsOptions: mysqlTLSOptions},
+	"redis":       {client: "REDIS", port: "6379"},
+	"rediss":      {client: "REDIS", port: "6379", tls: true},
+	"amqp":        {client: "AMQP", port: "5672"},
+	"amqps":       {client: "AMQP", port: "5671", tls: true},
+	"mongodb":     {client: "MONGODB"},
+	"mongodb+srv": {client: "MONGODB"},
+}
+
+// env var name suffixes that mark a connection URL
+var urlSuffixes = []string{"_URL", "_DSN"}
+
+// Return hosts for the connection URLs in envVars. A variable that already is a host field, like HTTP_BILLING_URL,
+// is skipped. One with a known scheme that doesn't parse fails a config check
+func GetURLHosts(envVars map[string]string) map[string]map[string]string {
+	res := make(map[string]map[string]string)
+	for key, val := range envVars {
+		id := urlHostID(key)
+		if id == "" {
+			continue
+		}
+		if _, ok := GetHostFromEV(key, val); ok {
+			continue
+		}
+		// look at the scheme before parsing, so a known scheme with a broken URL is reported instead of skipped
+		scheme := strings.SplitN(val, "://", 2)[0]
+		if _, ok := urlSchemes[strings.ToLower(scheme)]; !ok || !strings.Contains(val, "://") {
+			log.Debug(fmt.Sprintf("%s isn't a connection URL preflight knows", key))
+			continue
+		}
+		hMap, tlsIgnored, err := parseConnURL(val)
+		if err != nil {
+			// the value might hold a password, so only the error goes in the report
+			hostConfigFail(id, fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		if len(tlsIgnored) > 0 {
+			hostTLSIgnored(id, key, hMap["CLIENT"], tlsIgnored)
+		}
+		hMap["ID"], hMap["SOURCE"] = id, key
+		res[id] = hMap
+	}
+	return res
+}
+
+// Return the host ID for a connection URL variable name, or "" when the name doesn't end in one of the suffixes
+func urlHostID(key string) string {
+	for _, suffix := range urlSuffixes {
+		if strings.HasSuffix(key, suffix) && len(key) > len(suffix) {
+			return strings.TrimSuffix(key, suffix)
+		}
+	}
+	return ""
+}
+
+// Parse a connection URL into host fields: CLIENT from the scheme, ADDRESS, PORT (the scheme's default when it's
+// missing), USERNAME, PASSWORD, the database and the query options the client understands, like sslmode for SSLMODE.
+// Other options, like connect_timeout, are for the service's driver and are ignored. MongoDB URLs are kept whole in
+// URI because the MongoDB checker reads them itself
+func ParseConnURL(raw string) (map[string]string, error) {
+	hMap, _, err := parseConnURL(raw)
+	return hMap, err
+}
+
+// parseConnURL is ParseConnURL that also returns the options that ask for TLS the client can't check, like tls=true
+// on a mysql:// URL
+func parseConnURL(raw string) (map[string]string, []string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		// url errors quote the whole value, which might have a password in it
+		return nil, nil, fmt.Errorf("connection URL isn't valid")
+	}
+	scheme, ok := urlSchemes[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, nil, fmt.Errorf("connection URL scheme must be one of %s, not %q",
+			strings.Join(urlSchemeNames(), ", "), u.Scheme)
+	}
+	hMap := map[string]string{"CLIENT": scheme.client}
+	if scheme.client == "MONGODB" {
+		hMap["URI"] = raw
+		return hMap, nil, nil
+	}
+
+	if u.Hostname() == "" {
+		return nil, nil, fmt.Errorf("connection URL has no host")
+	}
+	hMap["ADDRESS"] = u.Hostname()
+	hMap["PORT"] = u.Port()
+	if hMap["PORT"] == "" {
+		hMap["PORT"] = scheme.port
+	}
+	if u.User != nil {
+		hMap["USERNAME"] = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			hMap["PASSWORD"] = password
+		}
+	}
+	if scheme.tls {
+		hMap["TLS"] = "true"
+	}
+
+	path := strings.TrimPrefix(u.Path, "/")
+	switch scheme.client {
+	case "REDIS":
+		if path != "" {
+			if _, err := strconv.Atoi(path); err != nil {
+				return nil, nil, fmt.Errorf("redis database has to be a number, not %q", path)
+			}
+			hMap["DB"] = path
+		}
+	case "AMQP":
+		// the default vhost "/" is %2F in the path
+		if path != "" {
+			hMap["VHOST"] = path
+		}
+	default:
+		if path != "" {
+			hMap["DATABASE"] = path
+		}
+	}
+
+	var ignored, tlsIgnored []string
+	for k, values := range u.Query() {
+		name, ok := scheme.options[strings.ToLower(k)]
+		if !ok {
+			if len(values) > 0 && asksForTLS(scheme, k, values[len(values)-1]) {
+				tlsIgnored = append(tlsIgnored, fmt.Sprintf("%s=%s", k, values[len(values)-1]))
+			} else {
+				ignored = append(ignored, k)
+			}
+			continue
+		}
+		if len(values) > 0 {
+			hMap[name] = values[len(values)-1]
+		}
+	}
+	if len(ignored) > 0 {
+		sort.Strings(ignored)
+		log.Debug(fmt.Sprintf("ignoring %s connection URL options preflight doesn't use: %s", scheme.client,
+			strings.Join(ignored, ", ")))
+	}
+	sort.Strings(tlsIgnored)
+	return hMap, tlsIgnored, nil
+}
+
+// Return true if the query option is one of the scheme's TLS options and doesn't turn TLS off
+func asksForTLS(scheme urlScheme, option, value string) bool {
+	for _, name := range scheme.tlsOptions {
+		if strings.EqualFold(option, name) {
+			return !tlsOffValues[strings.ToLower(value)]
+		}
+	}
+	return false
+}
+
+// Warn that the service asks for TLS in source, a connection URL, but host id is checked over plaintext. That check
+// fails against a server that requires secure transport and says nothing about TLS against one that doesn't
+func hostTLSIgnored(id, source, client string, options []string) {
+	msg := fmt.Sprintf("%s asks for TLS with %s, but %s hosts are checked without TLS", source,
+		strings.Join(options, ", "), client)
+	log.Warn(fmt.Sprintf("host %s: %s", id, msg))
+	report.Add(report.Record{
+		Name:     "config:hosts:" + id,
+		Category: report.Config,
+		Status:   report.Warn,
+		Error:    msg,
+		Remediation: fmt.Sprintf("check the server's TLS setup some other way. A server that requires secure "+
+			"transport fails the %s check, and a passing check doesn't cover TLS", client),
+	})
+}
+
+func urlSchemeNames() []string {
+	names := make([]string, 0, len(urlSchemes))
+	for name := range urlSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
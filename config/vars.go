@@ -0,0 +1,342 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/natemarks/preflight/report"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Entries in 'checked_environment_variables' are either a bare name, which only has to be set, or a map with rules
+// the value has to pass:
+//
+//	checked_environment_variables:
+//	  - DEPLOYMENT_COLOR
+//	  - name: PAYMENT_TIMEOUT
+//	    type: duration
+//	    min: 1s
+//	    max: 5m
+//	  - name: LOG_LEVEL
+//	    enum: [debug, info, warn, error]
+//	  - name: ORDER_PREFIX
+//	    regex: '^[A-Z]{3}$'
+//
+// min and max are compared against the number for int and port, the duration for duration, and the length of the
+// value for everything else.
+//
+// severity says what happens when the variable is unset, empty or breaks a rule. required (the default) fails the
+// run, warn adds a warning and info just notes it. default documents the value the service falls back to, so the
+// report can say what will happen:
+//
+//	  - name: FEATURE_X
+//	    severity: info
+//	    default: "off"
+
+// severities for VarSpec
+const (
+	SeverityRequired = "required"
+	SeverityWarn     = "warn"
+	SeverityInfo     = "info"
+)
+
+// VarSpec is an environment variable and the rules its value has to pass
+type VarSpec struct {
+	Name     string
+	Type     string
+	Regex    string
+	Min      string
+	Max      string
+	Enum     []string
+	Severity string
+	Default  string
+
+	pattern *regexp.Regexp
+}
+
+// each type's check returns false when the value isn't valid
+var varTypes = map[string]func(string) bool{
+	"int": func(v string) bool {
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	},
+	"bool": func(v string) bool {
+		_, err := strconv.ParseBool(v)
+		return err == nil
+	},
+	"duration": func(v string) bool {
+		_, err := time.ParseDuration(v)
+		return err == nil
+	},
+	"url": func(v string) bool {
+		u, err := url.Parse(v)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	},
+	"email": func(v string) bool {
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	},
+	"hostname": validHostname,
+	"port": func(v string) bool {
+		n, err := strconv.Atoi(v)
+		return err == nil && n > 0 && n <= 65535
+	},
+	"json": func(v string) bool {
+		return json.Valid([]byte(v))
+	},
+	"base64": func(v string) bool {
+		_, err := base64.StdEncoding.DecodeString(v)
+		return err == nil
+	},
+}
+
+// Return the specs from 'checked_environment_variables'. An entry with bad rules fails a config check, and its
+// variable is still checked for a value
+func GetVarSpecs() []VarSpec {
+	var entries []interface{}
+	switch raw := viper.Get("checked_environment_variables").(type) {
+	case []interface{}:
+		entries = raw
+	case []string:
+		for _, name := range raw {
+			entries = append(entries, name)
+		}
+	case string:
+		// from the PF_CHECKED_ENVIRONMENT_VARIABLES env var
+		for _, name := range strings.Fields(raw) {
+			entries = append(entries, name)
+		}
+	}
+	var specs []VarSpec
+	for i, entry := range entries {
+		spec, err := ParseVarSpec(entry)
+		if err != nil {
+			name := spec.Name
+			if name == "" {
+				name = fmt.Sprintf("entry %d", i+1)
+			}
+			log.Error(fmt.Sprintf("bad rule in 'checked_environment_variables' for %s: %v", name, err))
+			report.Add(report.Record{
+				Name:        "config:checked_environment_variables:" + name,
+				Category:    report.Config,
+				Status:      report.Fail,
+				Error:       err.Error(),
+				Remediation: fmt.Sprintf("fix the rules for %s in 'checked_environment_variables'", name),
+			})
+			if spec.Name == "" {
+				continue
+			}
+			spec = VarSpec{Name: spec.Name, Severity: SeverityRequired}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// Return names as specs without rules
+func VarSpecs(names []string) []VarSpec {
+	specs := make([]VarSpec, 0, len(names))
+	for _, name := range names {
+		specs = append(specs, VarSpec{Name: name, Severity: SeverityRequired})
+	}
+	return specs
+}
+
+// Build a spec from a 'checked_environment_variables' entry and check its rules make sense. The spec has the name
+// even when the rules are bad
+func ParseVarSpec(entry interface{}) (VarSpec, error) {
+	if name, ok := entry.(string); ok {
+		return VarSpec{Name: name, Severity: SeverityRequired}, nil
+	}
+	fields, ok := configMap(entry)
+	if !ok {
+		return VarSpec{}, fmt.Errorf("entry has to be a name or a map with a name, not %v", entry)
+	}
+
+	spec := VarSpec{Severity: SeverityRequired}
+	var unknown []string
+	for k, v := range fields {
+		switch strings.ToLower(k) {
+		case "name":
+			spec.Name = fmt.Sprint(v)
+		case "type":
+			spec.Type = strings.ToLower(fmt.Sprint(v))
+		case "regex":
+			spec.Regex = fmt.Sprint(v)
+		case "min":
+			spec.Min = fmt.Sprint(v)
+		case "max":
+			spec.Max = fmt.Sprint(v)
+		case "severity":
+			spec.Severity = strings.ToLower(fmt.Sprint(v))
+		case "default":
+			spec.Default = fmt.Sprint(v)
+		case "enum":
+			values, ok := v.([]interface{})
+			if !ok {
+				return spec, fmt.Errorf("enum has to be a list of values")
+			}
+			for _, value := range values {
+				spec.Enum = append(spec.Enum, fmt.Sprint(value))
+			}
+		default:
+			unknown = append(unknown, k)
+		}
+	}
+	if spec.Name == "" {
+		return spec, fmt.Errorf("entry has no name")
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return spec, fmt.Errorf("unknown rule %s", strings.Join(unknown, ", "))
+	}
+	if spec.Type != "" && varTypes[spec.Type] == nil {
+		return spec, fmt.Errorf("type must be one of %s, not %q", strings.Join(varTypeNames(), ", "), spec.Type)
+	}
+	if spec.Regex != "" {
+		pattern, err := regexp.Compile(spec.Regex)
+		if err != nil {
+			return spec, fmt.Errorf("regex isn't valid: %v", err)
+		}
+		spec.pattern = pattern
+	}
+	for _, limit := range []string{spec.Min, spec.Max} {
+		if limit == "" {
+			continue
+		}
+		if _, err := spec.limit(limit); err != nil {
+			unit := spec.unit()
+			if unit == "length" {
+				unit = "whole number length"
+			}
+			return spec, fmt.Errorf("min and max have to be a %s, not %q", unit, limit)
+		}
+	}
+	switch spec.Severity {
+	case SeverityRequired:
+		if spec.Default != "" {
+			return spec, fmt.Errorf("a required variable can't have a default, make it warn or info")
+		}
+	case SeverityWarn, SeverityInfo:
+	default:
+		return spec, fmt.Errorf("severity must be one of %s, %s or %s, not %q", SeverityRequired, SeverityWarn,
+			SeverityInfo, spec.Severity)
+	}
+	if spec.Default != "" {
+		if err := spec.Validate(spec.Default); err != nil {
+			return spec, fmt.Errorf("default doesn't pass the rules: %v", err)
+		}
+	}
+	return spec, nil
+}
+
+// Validate checks a value against the spec's rules. The error names the variable but never the value, which might
+// be a secret
+func (s VarSpec) Validate(val string) error {
+	if s.Type != "" && !varTypes[s.Type](val) {
+		return fmt.Errorf("%s is not a valid %s", s.Name, s.Type)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(val) {
+		return fmt.Errorf("%s doesn't match %s", s.Name, s.Regex)
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if val == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s has to be one of %s", s.Name, strings.Join(s.Enum, ", "))
+		}
+	}
+	if s.Min == "" && s.Max == "" {
+		return nil
+	}
+	n, err := s.measure(val)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid %s", s.Name, s.unit())
+	}
+	what := "value"
+	if s.unit() == "length" {
+		what = "length"
+	}
+	// limits were checked by ParseVarSpec
+	if s.Min != "" {
+		if min, _ := s.limit(s.Min); n < min {
+			return fmt.Errorf("%s is below the minimum %s %s", s.Name, what, s.Min)
+		}
+	}
+	if s.Max != "" {
+		if max, _ := s.limit(s.Max); n > max {
+			return fmt.Errorf("%s is above the maximum %s %s", s.Name, what, s.Max)
+		}
+	}
+	return nil
+}
+
+// measure turns a value into the number min and max are compared against
+func (s VarSpec) measure(v string) (float64, error) {
+	if s.unit() == "length" {
+		return float64(len(v)), nil
+	}
+	return s.limit(v)
+}
+
+// limit parses a min or max
+func (s VarSpec) limit(v string) (float64, error) {
+	if s.Type == "duration" {
+		d, err := time.ParseDuration(v)
+		return float64(d), err
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return float64(n), err
+}
+
+func (s VarSpec) unit() string {
+	switch s.Type {
+	case "int", "port":
+		return "whole number"
+	case "duration":
+		return "duration"
+	}
+	return "length"
+}
+
+func varTypeNames() []string {
+	names := make([]string, 0, len(varTypes))
+	for name := range varTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RFC 1123 host names: dot separated labels of letters, digits and hyphens that don't start or end with a hyphen
+func validHostname(v string) bool {
+	v = strings.TrimSuffix(v, ".")
+	if v == "" || len(v) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(v, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
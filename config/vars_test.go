@@ -0,0 +1,201 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/natemarks/preflight/report"
+	"github.com/spf13/viper"
+)
+
+func TestVarSpecValidate(t *testing.T) {
+	cases := []struct {
+		entry map[interface{}]interface{}
+		value string
+		err   string
+	}{
+		{map[interface{}]interface{}{"type": "int"}, "42", ""},
+		{map[interface{}]interface{}{"type": "int"}, "4.2", "is not a valid int"},
+		{map[interface{}]interface{}{"type": "bool"}, "true", ""},
+		{map[interface{}]interface{}{"type": "bool"}, "yes", "is not a valid bool"},
+		{map[interface{}]interface{}{"type": "duration"}, "1m30s", ""},
+		{map[interface{}]interface{}{"type": "duration"}, "30", "is not a valid duration"},
+		{map[interface{}]interface{}{"type": "url"}, "https://billing.domain.com/v1", ""},
+		{map[interface{}]interface{}{"type": "url"}, "billing.domain.com", "is not a valid url"},
+		{map[interface{}]interface{}{"type": "email"}, "ops@domain.com", ""},
+		{map[interface{}]interface{}{"type": "email"}, "Ops <ops@domain.com>", "is not a valid email"},
+		{map[interface{}]interface{}{"type": "hostname"}, "db-1.domain.com", ""},
+		{map[interface{}]interface{}{"type": "hostname"}, "db_1.domain.com", "is not a valid hostname"},
+		{map[interface{}]interface{}{"type": "port"}, "5432", ""},
+		{map[interface{}]interface{}{"type": "port"}, "70000", "is not a valid port"},
+		{map[interface{}]interface{}{"type": "json"}, `{"a": [1, 2]}`, ""},
+		{map[interface{}]interface{}{"type": "json"}, `{"a": `, "is not a valid json"},
+		{map[interface{}]interface{}{"type": "base64"}, "cHJlZmxpZ2h0", ""},
+		{map[interface{}]interface{}{"type": "base64"}, "not base64!", "is not a valid base64"},
+		{map[interface{}]interface{}{"regex": "^[A-Z]{3}$"}, "ORD", ""},
+		{map[interface{}]interface{}{"regex": "^[A-Z]{3}$"}, "ord", "doesn't match ^[A-Z]{3}$"},
+		{map[interface{}]interface{}{"enum": []interface{}{"debug", "info"}}, "info", ""},
+		{map[interface{}]interface{}{"enum": []interface{}{"debug", "info"}}, "trace", "has to be one of debug, info"},
+		{map[interface{}]interface{}{"type": "int", "min": 1, "max": 10}, "10", ""},
+		{map[interface{}]interface{}{"type": "int", "min": 1, "max": 10}, "11", "above the maximum value 10"},
+		{map[interface{}]interface{}{"type": "duration", "min": "1s", "max": "5m"}, "500ms", "below the minimum value 1s"},
+		{map[interface{}]interface{}{"min": 8}, "short", "below the minimum length 8"},
+		{map[interface{}]interface{}{"max": 8}, "short", ""},
+	}
+	for _, c := range cases {
+		c.entry["name"] = "PAYMENT_TIMEOUT"
+		spec, err := ParseVarSpec(c.entry)
+		if err != nil {
+			t.Errorf("%v: unexpected spec error %v", c.entry, err)
+			continue
+		}
+		err = spec.Validate(c.value)
+		if c.err == "" && err != nil {
+			t.Errorf("%v: expected %q to pass, got %v", c.entry, c.value, err)
+		}
+		if c.err != "" && (err == nil || !strings.HasPrefix(err.Error(), "PAYMENT_TIMEOUT ") ||
+			!strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%v: expected %q to fail with %q, got %v", c.entry, c.value, c.err, err)
+		}
+		if err != nil && strings.Contains(err.Error(), c.value) {
+			t.Errorf("%v: the error shouldn't contain the value: %v", c.entry, err)
+		}
+	}
+}
+
+func TestParseVarSpecErrors(t *testing.T) {
+	for _, entry := range []interface{}{
+		42,
+		map[interface{}]interface{}{"type": "int"},
+		map[interface{}]interface{}{"name": "A", "type": "float"},
+		map[interface{}]interface{}{"name": "A", "regex": "("},
+		map[interface{}]interface{}{"name": "A", "type": "duration", "min": "soon"},
+		map[interface{}]interface{}{"name": "A", "enum": "debug"},
+		map[interface{}]interface{}{"name": "A", "required": true},
+	} {
+		if _, err := ParseVarSpec(entry); err == nil {
+			t.Errorf("expected %v to be rejected", entry)
+		}
+	}
+}
+
+func TestCheckVarSpecs(t *testing.T) {
+	saved := viper.Get("checked_environment_variables")
+	defer viper.Set("checked_environment_variables", saved)
+	viper.Set("checked_environment_variables", []interface{}{
+		"DEPLOYMENT_COLOR",
+		map[interface{}]interface{}{"name": "PAYMENT_TIMEOUT", "type": "duration"},
+		map[interface{}]interface{}{"name": "PAYMENT_RETRIES", "type": "integer"},
+	})
+	_ = os.Setenv("DEPLOYMENT_COLOR", "RED")
+	_ = os.Setenv("PAYMENT_TIMEOUT", "30")
+	_ = os.Setenv("PAYMENT_RETRIES", "3")
+	defer func() {
+		_ = os.Unsetenv("PAYMENT_TIMEOUT")
+		_ = os.Unsetenv("PAYMENT_RETRIES")
+	}()
+
+	rep := report.Reset("test")
+	specs := GetVarSpecs()
+	vMap, ok := CheckVarSpecs(specs)
+	rep.Finish()
+	if ok || len(specs) != 3 {
+		t.Fatalf("expected 3 specs and a failure, got %v and %v", specs, ok)
+	}
+	// the bad rule fails a config check, but the variable is still checked for a value
+	if vMap["DEPLOYMENT_COLOR"] != "RED" || vMap["PAYMENT_RETRIES"] != "3" || vMap["PAYMENT_TIMEOUT"] != "" {
+		t.Errorf("unexpected variables: %v", vMap)
+	}
+	statuses := make(map[string]report.Record)
+	for _, rec := range rep.Records {
+		statuses[rec.Name] = rec
+	}
+	if rec := statuses["env:PAYMENT_TIMEOUT"]; rec.Status != report.Fail || rec.Error != "PAYMENT_TIMEOUT is not a valid duration" {
+		t.Errorf("unexpected record for PAYMENT_TIMEOUT: %+v", rec)
+	}
+	if rec := statuses["config:checked_environment_variables:PAYMENT_RETRIES"]; rec.Status != report.Fail {
+		t.Errorf("expected the bad type to fail a config check: %+v", rec)
+	}
+	if rec := statuses["env:DEPLOYMENT_COLOR"]; rec.Status != report.Pass {
+		t.Errorf("unexpected record for DEPLOYMENT_COLOR: %+v", rec)
+	}
+}
+
+// warn and info variables show up in the report without failing the run
+func TestCheckVarSeverity(t *testing.T) {
+	_ = os.Unsetenv("FEATURE_X")
+	_ = os.Unsetenv("FEATURE_Y")
+	_ = os.Setenv("FEATURE_Z", "maybe")
+	defer func() { _ = os.Unsetenv("FEATURE_Z") }()
+
+	var specs []VarSpec
+	for _, entry := range []map[interface{}]interface{}{
+		{"name": "FEATURE_X", "severity": "info", "default": "off"},
+		{"name": "FEATURE_Y", "severity": "warn"},
+		{"name": "FEATURE_Z", "severity": "warn", "type": "bool"},
+	} {
+		spec, err := ParseVarSpec(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		specs = append(specs, spec)
+	}
+	rep := report.Reset("test")
+	vMap, ok := CheckVarSpecs(specs)
+	rep.Finish()
+	if !ok || !rep.Success {
+		t.Errorf("expected optional variables not to fail the run: %+v", rep.Records)
+	}
+	if len(vMap) != 1 || vMap["FEATURE_X"] != "off" {
+		t.Errorf("expected only FEATURE_X with its default, got %v", vMap)
+	}
+	want := map[string]report.Record{
+		"env:FEATURE_X": {Status: report.Info, Error: "FEATURE_X unset, service will use default 'off'"},
+		"env:FEATURE_Y": {Status: report.Warn, Error: "FEATURE_Y unset"},
+		"env:FEATURE_Z": {Status: report.Warn, Error: "FEATURE_Z is not a valid bool"},
+	}
+	for _, rec := range rep.Records {
+		if w := want[rec.Name]; rec.Status != w.Status || rec.Error != w.Error {
+			t.Errorf("%s: expected %s %q, got %s %q", rec.Name, w.Status, w.Error, rec.Status, rec.Error)
+		}
+	}
+}
+
+// a warn variable that breaks its rules falls back to its default like an unset one
+func TestCheckVarInvalidDefault(t *testing.T) {
+	_ = os.Setenv("CACHE_TTL", "forever")
+	defer func() { _ = os.Unsetenv("CACHE_TTL") }()
+
+	spec, err := ParseVarSpec(map[interface{}]interface{}{"name": "CACHE_TTL", "severity": "warn", "type": "duration",
+		"default": "5m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := report.Reset("test")
+	val, ok := CheckVar(spec)
+	rep.Finish()
+	if !ok || val != "5m" {
+		t.Errorf("expected CACHE_TTL to pass with its default, got %q, %v", val, ok)
+	}
+	want := "CACHE_TTL is not a valid duration, service will use default '5m'"
+	if len(rep.Records) != 1 || rep.Records[0].Status != report.Warn || rep.Records[0].Error != want {
+		t.Errorf("expected a warn record %q, got %+v", want, rep.Records)
+	}
+}
+
+func TestParseVarSpecSeverity(t *testing.T) {
+	spec, err := ParseVarSpec("DEPLOYMENT_COLOR")
+	if err != nil || spec.Severity != SeverityRequired {
+		t.Errorf("expected bare names to be required, got %+v, %v", spec, err)
+	}
+	for _, entry := range []map[interface{}]interface{}{
+		{"name": "A", "severity": "fatal"},
+		{"name": "A", "default": "off"},
+		{"name": "A", "severity": "warn", "type": "int", "default": "off"},
+	} {
+		if _, err := ParseVarSpec(entry); err == nil {
+			t.Errorf("expected %v to be rejected", entry)
+		}
+	}
+}
@@ -1,10 +1,27 @@
 module github.com/natemarks/preflight
 
-go 1.13
+go 1.26.0
 
 require (
-	github.com/kami-zh/go-capturer v0.0.0-20171211120116-e492ea43421d
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/viper v1.6.1
-	golang.org/x/tools v0.0.0-20190328211700-ab21143f2384
+	golang.org/x/net v0.60.0
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/hashicorp/hcl v1.0.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/magiconair/properties v1.8.1 // indirect
+	github.com/mitchellh/mapstructure v1.1.2 // indirect
+	github.com/pelletier/go-toml v1.2.0 // indirect
+	github.com/spf13/afero v1.1.2 // indirect
+	github.com/spf13/cast v1.3.0 // indirect
+	github.com/spf13/jwalterweatherman v1.0.0 // indirect
+	github.com/spf13/pflag v1.0.3 // indirect
+	github.com/subosito/gotenv v1.2.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/text v0.42.0 // indirect
+	gopkg.in/ini.v1 v1.51.0 // indirect
+	gopkg.in/yaml.v2 v2.2.4 // indirect
 )
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/natemarks/preflight/config"
+	"github.com/natemarks/preflight/report"
+	"github.com/natemarks/preflight/server"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -18,6 +26,8 @@ const (
 
 func main() {
 	var liveCheck = flag.Bool("live_check", false, "check liveness file and exit")
+	var reportFormat = flag.String("report", "", "also write the results as a report. supported formats: json, junit")
+	var reportFile = flag.String("report-file", "", "file to write the report to. defaults to stdout")
 	flag.Parse()
 	formatter := &log.TextFormatter{
 		FullTimestamp: true,
@@ -27,9 +37,20 @@ func main() {
 	// Only log the info severity or above.
 	log.SetLevel(log.InfoLevel)
 
-	// I tried to move this to init() but it doesn't work there
-	log.SetOutput(os.Stdout)
+	// the report flags override the config file
+	if *reportFormat != "" {
+		viper.Set("report", *reportFormat)
+	}
+	if *reportFile != "" {
+		viper.Set("report_file", *reportFile)
+	}
+	// I tried to move this to init() but it doesn't work there. The config file can ask for a report too, so check
+	// again once it's read
+	setLogOutput()
 	config.GetSettings()
+	setLogOutput()
+	// tag every log entry with its audience, like [MyCompanyName:DevOps]
+	log.AddHook(&config.AudienceHook{})
 	if *liveCheck {
 		_, err := os.Stat(liveness_flag_file)
 		if err != nil {
@@ -38,6 +59,12 @@ func main() {
 			os.Exit(0)
 		}
 	}
+	switch flag.Arg(0) {
+	case "finalize":
+		Finalize(flag.Args()[1:])
+	case "serve":
+		Serve(flag.Args()[1:])
+	}
 	RealMain()
 }
 
@@ -51,9 +78,30 @@ func touch_liveness_file() {
 }
 
 func RealMain() {
+	setLogLevel()
+	log.Info(fmt.Sprintf("preflight version: %s", version))
+	touch_liveness_file()
+	if err := config.WriteState(config.StateFile(), config.NewRunState(version)); err != nil {
+		log.Warn(fmt.Sprintf("Unable to write state file %s: %v", config.StateFile(), err))
+	}
+
+	config.LogContainerMetadata()
+
+	rep := RunChecks()
+	if err := WriteReport(rep); err != nil {
+		log.Error(fmt.Sprintf("Unable to write report: %v", err))
+	}
+
+	// any failing check makes preflight exit non-zero
+	if rep.Success {
+		os.Exit(0)
+	} else {
+		os.Exit(1)
+	}
+
+}
 
-	// init success to true.  any failing check with set it to false
-	var success bool = true
+func setLogLevel() {
 	verbose, err := strconv.ParseBool(viper.GetString("verbose"))
 	if err != nil {
 		panic("Unable to get config key: verbose")
@@ -62,42 +110,197 @@ func RealMain() {
 		log.SetLevel(log.TraceLevel)
 		log.Debug("Verbose logging is enabled")
 	}
-	log.Info(fmt.Sprintf("preflight version: %s", version))
-	touch_liveness_file()
+}
 
-	config.LogContainerMetadata()
+// Log to stdout, unless the report goes there. Then the logs go to stderr, so the report can be piped to jq or a JUnit
+// collector on its own
+func setLogOutput() {
+	if reportToStdout() {
+		log.SetOutput(os.Stderr)
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+}
+
+// reportToStdout reports whether a report was asked for without a 'report_file' (or with '-')
+func reportToStdout() bool {
+	path := viper.GetString("report_file")
+	return viper.GetString("report") != "" && (path == "" || path == "-")
+}
+
+// RunChecks runs every check once and returns the finished report. The run passed if rep.Success is true
+func RunChecks() *report.Report {
+	rep := report.Reset(version)
 
 	// get the list of environment variables the service nees so we can check them
-	EnvVarsToCheck := viper.GetStringSlice("checked_environment_variables")
+	EnvVarsToCheck := config.GetVarSpecs()
 	if len(EnvVarsToCheck) == 0 {
-		success = false
 		msg := "Unable to get a list of environment variables to check. set 'checked_environment_variables' in the config"
 		log.Error(msg)
 	}
 
-	// make sure each of the required env vars has some set value
-	varMap, ok := config.CheckVars(EnvVarsToCheck)
+	// make sure each of the required env vars has some set value that passes its rules
+	varMap, ok := config.CheckVarSpecs(EnvVarsToCheck)
 	if !ok {
-		success = false
 		log.Error("Some required environment variables were not set")
 	}
 
 	// some  env vars might have data relevant to host checks.  capture that data into a map of host maps by ID
 	hostMap := config.GetHosts(varMap)
+	// then the hosts from connection URLs like DATABASE_URL, and the ones declared in the 'hosts' config section
+	config.MergeHosts(hostMap, config.GetURLHosts(varMap))
+	config.MergeHosts(hostMap, config.GetConfigHosts(varMap))
 
-	// temporarily drop the reachableHosts variable to run tests
-	// reachableHosts, err := config.GetReachableHosts(hostMap)
-	_, ok = config.GetReachableHosts(hostMap)
+	// fill in field defaults and drop the hosts that are missing a field their client needs
+	hostMap, ok = config.ValidateHosts(hostMap)
 	if !ok {
-		success = false
-		log.Error("Some hosts are not reachable")
+		log.Error("Some hosts failed field validation")
 	}
 
-	// success was initialized to true. Ay failing test would have set it to false
-	if success {
-		os.Exit(0)
+	// resolve, connect and check client access for all the hosts concurrently
+	_, ok = config.CheckHosts(hostMap)
+	if !ok {
+		log.Error("Some host checks failed")
+	}
+
+	// every check added a record to the report. any failed record fails the run
+	rep.Finish()
+	return rep
+}
+
+// WriteReport writes the report in the 'report' format to 'report_file', or stdout if no file is set. An empty format
+// means no report was asked for
+func WriteReport(rep *report.Report) error {
+	var write func(io.Writer) error
+	format := viper.GetString("report")
+	switch format {
+	case "":
+		return nil
+	case "json":
+		write = rep.WriteJSON
+	case "junit":
+		write = rep.WriteJUnit
+	default:
+		return fmt.Errorf("unsupported report format: %s", format)
+	}
+
+	out := os.Stdout
+	if path := viper.GetString("report_file"); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+		log.Info(fmt.Sprintf("Writing %s report to %s", format, path))
+	}
+	return write(out)
+}
+
+// Serve keeps preflight running as a sidecar that re-runs the checks every 'check_interval' and serves the results to
+// Kubernetes probes on 'listen_address' until it gets SIGINT or SIGTERM
+func Serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", viper.GetString("listen_address"), "address to serve /livez, /readyz and /checks on")
+	interval := fs.Duration("interval", viper.GetDuration("check_interval"), "time between check runs")
+	_ = fs.Parse(args)
+	if err := checkInterval(*interval); err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "%v\n", err)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	setLogLevel()
+	log.Info(fmt.Sprintf("preflight version: %s", version))
+	config.LogContainerMetadata()
+
+	srv := server.New(func() server.Status {
+		rep := RunChecks()
+		return server.Status{Time: rep.Finished, OK: rep.Success, Failures: rep.Failures()}
+	}, *interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	go srv.Run(ctx)
+
+	httpServer := &http.Server{Addr: *listen, Handler: srv.Handler()}
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Shutdown(context.Background())
+	}()
+	log.Info(fmt.Sprintf("Serving probes on %s, checking every %s", *listen, *interval))
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	os.Exit(0)
+}
+
+// shortest time between check runs in serve mode. Anything shorter is almost always a 'check_interval' without a
+// unit, which viper reads as nanoseconds
+const minCheckInterval = time.Second
+
+// Return an error for a check interval that would panic the ticker or keep the checks running back to back
+func checkInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("the check interval has to be positive, not %s", interval)
+	}
+	if interval < minCheckInterval {
+		return fmt.Errorf("the check interval %s is shorter than %s. durations need a unit, like 30s", interval,
+			minCheckInterval)
+	}
+	return nil
+}
+
+// Finalize runs after the service exits and logs how it went: the exit status, how long it ran since the preflight
+// run and the container metadata. It exits with the service's exit status so the entrypoint script keeps it
+func Finalize(args []string) {
+	fs := flag.NewFlagSet("finalize", flag.ExitOnError)
+	exitCode := fs.Int("exit_code", -1, "exit status of the service. -1 means unknown")
+	_ = fs.Parse(args)
+
+	fields := log.Fields{
+		"event":     "finalize",
+		"exit_code": *exitCode,
+	}
+	runtime := "unknown"
+	state, err := config.ReadState(config.StateFile())
+	if err != nil {
+		log.Warn(fmt.Sprintf("Unable to read state file %s: %v", config.StateFile(), err))
 	} else {
-		os.Exit(1)
+		duration := time.Since(state.Started).Round(time.Second)
+		runtime = duration.String()
+		fields["started"] = state.Started.Format(time.RFC3339)
+		fields["runtime_seconds"] = int64(duration.Seconds())
+		fields["stable"] = duration >= viper.GetDuration("stable_after")
+		fields["preflight_version"] = state.Version
+	}
+
+	msg := fmt.Sprintf("preflight finalize: service exited with status %d after running %s", *exitCode, runtime)
+	if *exitCode == 0 {
+		log.WithFields(fields).Info(msg)
+	} else {
+		log.WithFields(fields).Error(msg)
 	}
+	config.LogContainerMetadata()
+
+	os.Exit(exitStatus(*exitCode))
+}
 
+// Return the status to exit with for the service's exit code. Exit statuses only have 8 bits, so 256 would exit 0.
+// Anything that isn't 0, including the unknown -1, is kept between 1 and 255 so it can't pass for success
+func exitStatus(code int) int {
+	switch {
+	case code == 0:
+		return 0
+	case code < 1:
+		return 1
+	case code > 255:
+		return 255
+	}
+	return code
 }
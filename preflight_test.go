@@ -1 +1,60 @@
 package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/natemarks/preflight/report"
+	"github.com/natemarks/preflight/utility"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestCheckInterval(t *testing.T) {
+	for _, c := range []struct {
+		interval time.Duration
+		ok       bool
+	}{
+		{30 * time.Second, true},
+		{time.Second, true},
+		{0, false},
+		{-time.Minute, false},
+		// check_interval: 30 without a unit
+		{30, false},
+	} {
+		if err := checkInterval(c.interval); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.interval, c.ok, err)
+		}
+	}
+}
+
+// a report on stdout has to parse on its own, so the logs can't go there too
+func TestReportToStdout(t *testing.T) {
+	viper.Set("report", "json")
+	viper.Set("report_file", "")
+	defer viper.Set("report", "")
+	defer log.SetOutput(os.Stderr)
+	rep := report.Reset("test")
+	rep.Finish()
+	out := utility.CapOut(func() {
+		setLogOutput()
+		log.Info("this goes to stderr")
+		if err := WriteReport(rep); err != nil {
+			t.Error(err)
+		}
+	})
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &got); err != nil || got["version"] != "test" {
+		t.Errorf("expected stdout to be just the report, got %v: %q", err, out)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	for code, want := range map[int]int{0: 0, 1: 1, 137: 137, 255: 255, 256: 255, 1000: 255, -1: 1, -9: 1} {
+		if got := exitStatus(code); got != want {
+			t.Errorf("exitStatus(%d) = %d, expected %d", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// JUnit XML lets CI servers (GitLab, Jenkins, ...) show preflight results in their test UI. Every record becomes a
+// testcase, grouped into one testsuite per category. JUnit has no notion of a warning, so warnings pass and carry
+// their message in system-out
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as a JUnit XML document
+func (r *Report) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	doc := junitTestSuites{
+		Name: "preflight " + r.Version,
+		Time: seconds(r.Finished.Sub(r.Started).Seconds()),
+	}
+	suites := make(map[Category]*junitTestSuite)
+	var order []Category
+	for _, rec := range r.Records {
+		suite, ok := suites[rec.Category]
+		if !ok {
+			suite = &junitTestSuite{Name: "preflight." + string(rec.Category), Timestamp: r.Started.Format("2006-01-02T15:04:05")}
+			suites[rec.Category] = suite
+			order = append(order, rec.Category)
+		}
+		tc := junitTestCase{
+			Name:      rec.Name,
+			ClassName: "preflight." + string(rec.Category),
+			Time:      seconds(rec.Duration.Seconds()),
+		}
+		switch rec.Status {
+		case Fail:
+			tc.Failure = &junitFailure{Message: rec.Error, Type: string(rec.Category), Text: rec.Remediation}
+			suite.Failures++
+		case Skip:
+			tc.Skipped = &struct{}{}
+			suite.Skipped++
+		case Warn:
+			tc.SystemOut = fmt.Sprintf("warning: %s. %s", rec.Error, rec.Remediation)
+		case Info:
+			tc.SystemOut = fmt.Sprintf("info: %s", rec.Error)
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	for _, c := range order {
+		suite := suites[c]
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Skipped += suite.Skipped
+		doc.Suites = append(doc.Suites, *suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
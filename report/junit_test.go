@@ -0,0 +1,49 @@
+package report
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	r := New("v1.2.3")
+	r.Add(Record{Name: "env:DEPLOYMENT_COLOR", Category: EnvVar, Status: Pass})
+	r.Add(Record{Name: "env:FEATURE_X", Category: EnvVar, Status: Warn, Error: "not set", Remediation: "defaults to off"})
+	r.Add(Record{Name: "tcp:HOT_PICKLES", Category: TCP, HostID: "HOT_PICKLES", Status: Fail,
+		Duration: 3 * time.Second, Error: "unable to connect to 10.0.0.1:5432", Remediation: "open the port"})
+	r.Add(Record{Name: "client:HOT_PICKLES", Category: Client, HostID: "HOT_PICKLES", Status: Skip})
+	r.Finish()
+
+	var buf bytes.Buffer
+	if err := r.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Error("missing xml header")
+	}
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	if doc.Tests != 4 || doc.Failures != 1 || doc.Skipped != 1 || len(doc.Suites) != 3 {
+		t.Fatalf("unexpected totals:\n%s", buf.String())
+	}
+	env, tcp, client := doc.Suites[0], doc.Suites[1], doc.Suites[2]
+	if env.Name != "preflight.env" || env.Tests != 2 || env.Cases[1].SystemOut != "warning: not set. defaults to off" {
+		t.Errorf("unexpected env suite: %+v", env)
+	}
+	failure := tcp.Cases[0].Failure
+	if failure == nil || failure.Message != "unable to connect to 10.0.0.1:5432" || failure.Text != "open the port" {
+		t.Errorf("unexpected tcp failure: %+v", failure)
+	}
+	if tcp.Cases[0].Time != "3.000" {
+		t.Errorf("testcase time = %s", tcp.Cases[0].Time)
+	}
+	if client.Cases[0].Skipped == nil {
+		t.Error("skipped check should be a skipped testcase")
+	}
+}
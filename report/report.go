@@ -0,0 +1,156 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every check preflight runs (env vars, name resolution, tcp, tls, client access) adds a Record to the report. The
+// report decides whether the run passed and is what gets written out for deploy tooling, so nobody has to regex the
+// logs.
+//
+// Like the logrus and viper globals, there's a package level report the checks write to with Add. A run starts with
+// Reset and ends with Finish.
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Warn Status = "warn"
+	Info Status = "info" // worth knowing about, but nothing needs fixing
+	Skip Status = "skip" // not run because a check it depends on failed
+)
+
+// Category groups checks by what they look at
+type Category string
+
+const (
+	Config  Category = "config"
+	EnvVar  Category = "env"
+	Resolve Category = "resolve"
+	TCP     Category = "tcp"
+	TLS     Category = "tls"
+	Client  Category = "client"
+)
+
+// the order categories run in, used to sort records
+var categoryOrder = map[Category]int{Config: 0, EnvVar: 1, Resolve: 2, TCP: 3, TLS: 4, Client: 5}
+
+// Record is the result of one check
+type Record struct {
+	Name        string        `json:"name"`
+	Category    Category      `json:"category"`
+	HostID      string        `json:"host_id,omitempty"`
+	Client      string        `json:"client,omitempty"`
+	Status      Status        `json:"status"`
+	Duration    time.Duration `json:"-"`
+	DurationMS  float64       `json:"duration_ms"`
+	Error       string        `json:"error,omitempty"`
+	Remediation string        `json:"remediation,omitempty"`
+}
+
+// Report is a whole preflight run
+type Report struct {
+	mu       sync.Mutex
+	Version  string         `json:"version"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Success  bool           `json:"success"`
+	Summary  map[Status]int `json:"summary"`
+	Records  []Record       `json:"records"`
+}
+
+// New starts an empty report
+func New(version string) *Report {
+	return &Report{
+		Version: version,
+		Started: time.Now(),
+		Summary: make(map[Status]int),
+		Records: []Record{},
+	}
+}
+
+// Add records the result of a check. It's safe to call from concurrent checks
+func (r *Report) Add(rec Record) {
+	rec.DurationMS = float64(rec.Duration) / float64(time.Millisecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Records = append(r.Records, rec)
+}
+
+// Finish sorts the records into a stable order and works out the summary. The run is a success when nothing failed
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+	sort.SliceStable(r.Records, func(i, j int) bool {
+		a, b := r.Records[i], r.Records[j]
+		if a.HostID != b.HostID {
+			return a.HostID < b.HostID
+		}
+		if a.Category != b.Category {
+			return categoryOrder[a.Category] < categoryOrder[b.Category]
+		}
+		return a.Name < b.Name
+	})
+	r.Summary = make(map[Status]int)
+	r.Success = true
+	for _, rec := range r.Records {
+		r.Summary[rec.Status]++
+		if rec.Status == Fail {
+			r.Success = false
+		}
+	}
+}
+
+// Failures returns the names of the failed checks
+func (r *Report) Failures() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []string{}
+	for _, rec := range r.Records {
+		if rec.Status == Fail {
+			res = append(res, rec.Name)
+		}
+	}
+	return res
+}
+
+// WriteJSON writes the report as an indented json document
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+var (
+	stdMu sync.Mutex
+	std   = New("")
+)
+
+// Reset replaces the package level report with an empty one and returns it
+func Reset(version string) *Report {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	std = New(version)
+	return std
+}
+
+// Current returns the package level report
+func Current() *Report {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	return std
+}
+
+// Add records the result of a check in the package level report
+func Add(rec Record) {
+	Current().Add(rec)
+}
@@ -0,0 +1,95 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReportFinish(t *testing.T) {
+	r := New("v1.2.3")
+	// added out of order, like concurrent host checks would
+	r.Add(Record{Name: "client:B", Category: Client, HostID: "B", Status: Fail})
+	r.Add(Record{Name: "tcp:A", Category: TCP, HostID: "A", Status: Pass})
+	r.Add(Record{Name: "env:Z", Category: EnvVar, Status: Pass})
+	r.Add(Record{Name: "resolve:A", Category: Resolve, HostID: "A", Status: Pass})
+	r.Add(Record{Name: "env:Y", Category: EnvVar, Status: Warn})
+	r.Finish()
+
+	var names []string
+	for _, rec := range r.Records {
+		names = append(names, rec.Name)
+	}
+	want := []string{"env:Y", "env:Z", "resolve:A", "tcp:A", "client:B"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("records in order %v; want %v", names, want)
+		}
+	}
+	if r.Success {
+		t.Error("a failed record should fail the run")
+	}
+	if r.Summary[Pass] != 3 || r.Summary[Fail] != 1 || r.Summary[Warn] != 1 {
+		t.Errorf("summary = %v", r.Summary)
+	}
+	if f := r.Failures(); len(f) != 1 || f[0] != "client:B" {
+		t.Errorf("Failures() = %v", f)
+	}
+}
+
+// warnings, info and skips don't fail a run
+func TestReportSuccess(t *testing.T) {
+	r := New("v1.2.3")
+	r.Add(Record{Name: "env:X", Category: EnvVar, Status: Warn})
+	r.Add(Record{Name: "env:Y", Category: EnvVar, Status: Info})
+	r.Add(Record{Name: "client:A", Category: Client, HostID: "A", Status: Skip})
+	r.Finish()
+	if !r.Success {
+		t.Fail()
+	}
+}
+
+func TestReportWriteJSON(t *testing.T) {
+	r := New("v1.2.3")
+	r.Add(Record{Name: "tcp:A", Category: TCP, HostID: "A", Status: Fail, Duration: 1500 * time.Microsecond,
+		Error: "unable to connect", Remediation: "open the port"})
+	r.Finish()
+
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version string `json:"version"`
+		Success bool   `json:"success"`
+		Records []map[string]interface{}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "v1.2.3" || doc.Success || len(doc.Records) != 1 {
+		t.Fatalf("unexpected document: %s", buf.String())
+	}
+	rec := doc.Records[0]
+	if rec["host_id"] != "A" || rec["status"] != "fail" || rec["duration_ms"] != 1.5 || rec["remediation"] != "open the port" {
+		t.Errorf("unexpected record: %v", rec)
+	}
+}
+
+func TestPackageReportConcurrentAdd(t *testing.T) {
+	r := Reset("v0")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Add(Record{Name: "tcp:A", Category: TCP, Status: Pass})
+		}()
+	}
+	wg.Wait()
+	if Current() != r || len(r.Records) != 50 {
+		t.Errorf("expected 50 records, got %d", len(r.Records))
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Server re-runs the preflight checks on a schedule and exposes the latest result for Kubernetes httpGet probes:
+//   /livez   200 while the check loop keeps completing runs, 503 if it's stuck
+//   /readyz  200 when the last run passed, 503 with the failing checks when it didn't (or hasn't run yet)
+//   /checks  the last run as json, whether it passed or not
+
+// Status is the outcome of one run of the checks
+type Status struct {
+	Time     time.Time `json:"time"`
+	OK       bool      `json:"ok"`
+	Failures []string  `json:"failures"`
+}
+
+// CheckFunc runs every check once and reports the outcome
+type CheckFunc func() Status
+
+type Server struct {
+	check    CheckFunc
+	interval time.Duration
+
+	mu   sync.RWMutex
+	last *Status
+}
+
+// New returns a server that runs check every interval
+func New(check CheckFunc, interval time.Duration) *Server {
+	return &Server{check: check, interval: interval}
+}
+
+// Run checks right away and then every interval until ctx is done
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.runOnce()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) runOnce() {
+	status := s.check()
+	if status.Failures == nil {
+		status.Failures = []string{}
+	}
+	s.mu.Lock()
+	s.last = &status
+	s.mu.Unlock()
+}
+
+// Last returns the latest status and false if no run has finished yet
+func (s *Server) Last() (Status, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.last == nil {
+		return Status{}, false
+	}
+	return *s.last, true
+}
+
+// Handler serves the probe endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", s.livez)
+	mux.HandleFunc("/readyz", s.readyz)
+	mux.HandleFunc("/checks", s.checks)
+	return mux
+}
+
+// The loop is considered stuck when the last completed run is more than three intervals old. Before the first run
+// finishes the process is still starting, which isn't a reason to restart it
+func (s *Server) livez(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.Last()
+	if ok && time.Since(status.Time) > 3*s.interval {
+		http.Error(w, "check loop is stuck", http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.Last()
+	if !ok {
+		http.Error(w, "checks haven't run yet", http.StatusServiceUnavailable)
+		return
+	}
+	code := http.StatusOK
+	if !status.OK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func (s *Server) checks(w http.ResponseWriter, r *http.Request) {
+	status, ok := s.Last()
+	if !ok {
+		http.Error(w, "checks haven't run yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Unable to write response: ", err)
+	}
+}
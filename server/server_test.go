@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeChecks passes or fails depending on what the test last told it
+type fakeChecks struct {
+	mu       sync.Mutex
+	failures []string
+	runs     int
+}
+
+func (f *fakeChecks) set(failures ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = failures
+}
+
+func (f *fakeChecks) check() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs++
+	return Status{Time: time.Now(), OK: len(f.failures) == 0, Failures: f.failures}
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, Status) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	var status Status
+	_ = json.Unmarshal(rec.Body.Bytes(), &status)
+	return rec.Code, status
+}
+
+func TestServerBeforeFirstRun(t *testing.T) {
+	s := New((&fakeChecks{}).check, time.Minute)
+	h := s.Handler()
+	if code, _ := get(t, h, "/livez"); code != http.StatusOK {
+		t.Errorf("/livez = %d before the first run; want 200", code)
+	}
+	if code, _ := get(t, h, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d before the first run; want 503", code)
+	}
+}
+
+// A dependency going away flips /readyz to 503 on the next run, and back when it returns
+func TestServerReadyz(t *testing.T) {
+	checks := &fakeChecks{}
+	s := New(checks.check, time.Minute)
+	h := s.Handler()
+
+	s.runOnce()
+	code, status := get(t, h, "/readyz")
+	if code != http.StatusOK || !status.OK {
+		t.Errorf("/readyz = %d %+v; want 200", code, status)
+	}
+
+	checks.set("host:HOT_PICKLES (POSTGRES10)")
+	s.runOnce()
+	code, status = get(t, h, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz = %d; want 503", code)
+	}
+	if len(status.Failures) != 1 || status.Failures[0] != "host:HOT_PICKLES (POSTGRES10)" {
+		t.Errorf("failures = %v", status.Failures)
+	}
+	// /checks always answers 200 with the last run
+	code, status = get(t, h, "/checks")
+	if code != http.StatusOK || status.OK {
+		t.Errorf("/checks = %d %+v", code, status)
+	}
+
+	checks.set()
+	s.runOnce()
+	if code, _ := get(t, h, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz = %d after recovery; want 200", code)
+	}
+}
+
+func TestServerLivezStuck(t *testing.T) {
+	s := New(func() Status { return Status{Time: time.Now().Add(-time.Hour), OK: true} }, time.Second)
+	s.runOnce()
+	if code, _ := get(t, s.Handler(), "/livez"); code != http.StatusServiceUnavailable {
+		t.Errorf("/livez = %d for a stale run; want 503", code)
+	}
+}
+
+func TestServerRunRepeats(t *testing.T) {
+	checks := &fakeChecks{}
+	s := New(checks.check, 10*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+	checks.mu.Lock()
+	defer checks.mu.Unlock()
+	if checks.runs < 3 {
+		t.Errorf("expected several runs in 100ms, got %d", checks.runs)
+	}
+}